@@ -1,32 +1,32 @@
+// Package ca is the certificate authority that signs registrations: it
+// checks the email in a registration request and signs the transaction,
+// bound to a fresh nonce, so nodes can tell the registration came through
+// it and each signature is only good once.
 package ca
 
 import (
-	"crypto"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
 	"encoding/json"
 	"encoding/pem"
-	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
-	"log"
 	"net/http"
 	"regexp"
-	"strconv"
 	"time"
 
 	"github.com/stretchr/graceful"
-	"github.com/tslilyai/SLYcoin"
+	"github.com/tslilyai/SLYkey/txn"
 )
 
 var (
-	port        = flag.Int("port", 8080, "HTTP port")
-	privKeyFile = flag.String("key", "rsa_pub", "Private Key File")
 	ErrPost     = "must use POST"
 	ErrDecode   = "bad transaction json data"
 	ErrBadEmail = "bad email address"
 	ErrBadType  = "must be a request transaction"
+	ErrBadKey   = "must register a usable public key"
 )
 
 // App defines an application that can be run
@@ -34,18 +34,22 @@ type App interface {
 	Run() error
 }
 
-// NewApp returns a new application to run
-func NewApp() (App, error) {
+// NewApp returns a new application to run, serving on addr and signing
+// registrations on the network chainID with the PEM private key in keyFile
+func NewApp(addr string, keyFile string, chainID string) (App, error) {
 	handler := http.NewServeMux()
 	server := &graceful.Server{
 		Server: &http.Server{
-			Addr:    ":" + strconv.Itoa(*port),
+			Addr:    addr,
 			Handler: handler,
 		},
 		Timeout: 2 * time.Second,
 	}
-	priv := getPrivateKey()
-	app := &app{server, priv}
+	priv, err := getPrivateKey(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	app := &app{server, priv, chainID}
 
 	handler.HandleFunc("/register", func(w http.ResponseWriter, r *http.Request) {
 		app.registerReq(w, r)
@@ -57,6 +61,7 @@ func NewApp() (App, error) {
 type app struct {
 	server     *graceful.Server
 	privateKey *rsa.PrivateKey
+	chainID    string // the network whose registrations we sign
 }
 
 // Run starts the app
@@ -70,27 +75,76 @@ func (a *app) registerReq(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	// attempt to decode data
-	var data main.Transaction
+	var data txn.Transaction
 	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
 		http.Error(w, ErrDecode, http.StatusBadRequest)
+		return
 	}
 	// validate the registration type
-	if data.Type != "register" {
+	if data.Type != txn.Register {
 		http.Error(w, ErrBadType, http.StatusBadRequest)
+		return
 	}
 	// validate the email
 	if !validateEmail(data.Email) {
 		http.Error(w, ErrBadEmail, http.StatusBadRequest)
+		return
+	}
+	// and the keys, which nodes would refuse anyway
+	if !txn.UsableKey(&data.PublicKey) || (data.RecoveryKey.N != nil && !txn.UsableKey(&data.RecoveryKey)) {
+		http.Error(w, ErrBadKey, http.StatusBadRequest)
+		return
 	}
 
-	// sign the transaction, with the hash of the transaction json (without signature)
-	s, err := rsa.SignPKCS1v15(rand.Reader, a.privateKey, crypto.SHA256, []byte(r.Body))
+	reg, err := SignRegistration(a.privateKey, &data, a.chainID)
 	if err != nil {
 		http.Error(w, "Could not sign registration request", http.StatusInternalServerError)
+		return
 	}
 
-	// return the signature of the hash of the email
-	fmt.Fprintf(w, s)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(reg)
+}
+
+// Registration is the CA's answer to a registration request: the nonce the
+// registration has to carry, and the signature over it
+type Registration struct {
+	Nonce     []byte `json:"nonce"`
+	Signature []byte `json:"signature"`
+}
+
+// SignRegistration binds t's registration to a fresh nonce and signs it
+// with the CA's key for the network chainID, the way nodes check it (see
+// txn.SigningPayload), along with t's recovery key if it has one. Whatever
+// sequence number, previous key or nonce t came with is ignored. It won't
+// sign a registration without a usable key.
+func SignRegistration(priv *rsa.PrivateKey, t *txn.Transaction, chainID string) (*Registration, error) {
+	return SignRegistrationFrom(rand.Reader, priv, t, chainID)
+}
+
+// SignRegistrationFrom is SignRegistration with the nonce read from nonces,
+// so the simulator (package sim) can replay a run. A real CA uses
+// SignRegistration.
+func SignRegistrationFrom(nonces io.Reader, priv *rsa.PrivateKey, t *txn.Transaction, chainID string) (*Registration, error) {
+	if !txn.UsableKey(&t.PublicKey) {
+		return nil, fmt.Errorf("no key to register")
+	}
+	unsigned := txn.Transaction{
+		Type:      txn.Register,
+		Email:     t.Email,
+		PublicKey: t.PublicKey,
+		Nonce:     make([]byte, txn.NonceSize),
+		// the recovery key is the registrant's to choose
+		RecoveryKey:   t.RecoveryKey,
+		RecoveryDelay: t.RecoveryDelay,
+	}
+	if _, err := io.ReadFull(nonces, unsigned.Nonce); err != nil {
+		return nil, err
+	}
+	if err := txn.Sign(&unsigned, priv, chainID); err != nil {
+		return nil, err
+	}
+	return &Registration{Nonce: unsigned.Nonce, Signature: unsigned.Signature}, nil
 }
 
 func validateEmail(email string) bool {
@@ -98,21 +152,24 @@ func validateEmail(email string) bool {
 	return Re.MatchString(email)
 }
 
-func getPrivateKey() *rsa.PrivateKey {
+func getPrivateKey(keyFile string) (*rsa.PrivateKey, error) {
 	// Extract the PEM-encoded data block
-	pemData := ioutil.ReadFile(*privKeyFile)
+	pemData, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
 	block, _ := pem.Decode(pemData)
 	if block == nil {
-		log.Fatalf("bad key data: %s", "not PEM-encoded")
+		return nil, fmt.Errorf("bad key data: %s", "not PEM-encoded")
 	}
 	if got, want := block.Type, "RSA PRIVATE KEY"; got != want {
-		log.Fatalf("unknown key type %q, want %q", got, want)
+		return nil, fmt.Errorf("unknown key type %q, want %q", got, want)
 	}
 
 	// Decode the RSA private key
 	priv, err := x509.ParsePKCS1PrivateKey(block.Bytes)
 	if err != nil {
-		log.Fatalf("bad private key: %s", err)
+		return nil, fmt.Errorf("bad private key: %s", err)
 	}
-	return priv
+	return priv, nil
 }
@@ -0,0 +1,154 @@
+// Package chain is the SLYkey block chain itself: blocks and headers, the
+// consensus rules they follow, the key directory they build up with its
+// proofs, and where blocks and directory snapshots are stored.
+package chain
+
+import (
+	"crypto/rsa"
+	"crypto/sha256"
+	"fmt"
+
+	"github.com/tslilyai/SLYkey/txn"
+)
+
+const (
+	NumZeros = 28
+	NumTries = 5000
+)
+
+// a Block is its header plus the body the header commits to. SeqNum is our
+// position in the chain and Hash is the header hash; neither is hashed.
+type Block struct {
+	BlockHeader
+	Transactions []txn.Transaction
+	SeqNum       uint64
+	Hash         [sha256.Size]byte
+}
+
+// Genesis is the dummy block every chain on the network starts from. It
+// isn't mined, but its hash is still that of its header so proofs against
+// it check out like any other.
+func (p *ChainParams) Genesis() Block {
+	b := Block{
+		BlockHeader: BlockHeader{
+			Version:    BlockVersion,
+			Difficulty: p.InitialDifficulty,
+		},
+		Transactions: nil,
+		SeqNum:       0,
+	}
+	b.Hash = b.GetHash()
+	return b
+}
+
+// gets the hash of the block when the proof of work is already computed
+func (b *Block) GetHash() [sha256.Size]byte {
+	return b.BlockHeader.Hash()
+}
+
+// compute and set the proof of work and hash of the block: fill in the rest
+// of the header and search for a nonce whose header hash meets the block's
+// own Difficulty, so set that (and Timestamp) first.
+// every NumTries hashes we check c for a new block to build on; if one
+// shows up we give up on this one and return it (with true) so the caller
+// can start over on top of it
+func (b *Block) SetProofOfWork(parentHash [sha256.Size]byte, c chan Block) (Block, bool) {
+	b.Version = BlockVersion
+	b.ParentHash = parentHash
+	b.MerkleRoot = MerkleRoot(b.Transactions)
+	checksum := [sha256.Size]byte{}
+
+	for b.Nonce = 0; ; b.Nonce++ {
+		if b.Nonce%NumTries == 0 {
+			select {
+			case pBlock := <-c:
+				return pBlock, true
+			default:
+			}
+		}
+		checksum = b.BlockHeader.Hash()
+		if HashMeetsDifficulty(checksum, b.Difficulty) {
+			break
+		}
+	}
+	b.Hash = checksum
+	return Block{}, false
+}
+
+// ChainView is the chain a block is validated against: the rules of its
+// network and its blocks by SeqNum, up to at least the block's parent
+type ChainView interface {
+	Params() *ChainParams
+	Get(seqNum uint64) (Block, bool)
+}
+
+type storeView struct {
+	BlockStore
+	params *ChainParams
+}
+
+func (v storeView) Params() *ChainParams {
+	return v.params
+}
+
+// NewStoreView views the blocks in s as a chain following params
+func NewStoreView(s BlockStore, params *ChainParams) ChainView {
+	return storeView{s, params}
+}
+
+// verify proof of work -- invariant: the parent is in chain
+// 		- check that the block's parent's hash matches the hash of the parent block (seqNum - 1)
+// 		- check that the block carries the difficulty the retarget rule says
+// 		- check that its timestamp is past the median time of the blocks before it
+// 		- check that it isn't too far ahead of now (unix seconds)
+func (b *Block) ValidateHash(chain ChainView, now int64) error {
+	// VALIDATE BLOCK'S HASH (Proof of Work)
+	parent, _ := chain.Get(b.SeqNum - 1)
+	if b.ParentHash != parent.Hash {
+		return fmt.Errorf("invalid parent block hash")
+	}
+	ancestor := func(seqNum uint64) Block {
+		a, _ := chain.Get(seqNum)
+		return a
+	}
+	params := chain.Params()
+	if b.Difficulty != params.NextDifficulty(parent, ancestor) || b.Difficulty < params.MinDifficulty {
+		return fmt.Errorf("wrong difficulty %v", b.Difficulty)
+	}
+	if !CheckTimestamp(*b, MedianTimePast(parent, ancestor), now) {
+		return fmt.Errorf("bad timestamp %v", b.Timestamp)
+	}
+	// ensure that block hash matches the header (which includes the proof of work)
+	if b.GetHash() != b.Hash {
+		return fmt.Errorf("hash does not match the block header")
+	}
+	if !HashMeetsDifficulty(b.Hash, b.Difficulty) {
+		return fmt.Errorf("invalid proof of work, hash does not meet the block's difficulty")
+	}
+	if b.MerkleRoot != MerkleRoot(b.Transactions) {
+		return fmt.Errorf("merkle root does not match the transactions")
+	}
+	return nil
+}
+
+// validate the transations in a block on the network params, assuming that
+// db is correct up until this block; registrations must be signed by caKey
+func (b *Block) ValidateTxn(db *Directory, params *ChainParams, caKey *rsa.PublicKey) error {
+	// local copy of the database, keeps track of multiple user transactions in the same block
+	BlockDatabase := make(map[string]txn.Identity)
+	for i := range b.Transactions {
+		t := &b.Transactions[i]
+		last := db.Lookup(t.Email)
+		if id, ok := BlockDatabase[t.Email]; ok {
+			// prior update found in the current block
+			last = &id
+		}
+		// a recovery whose delay is up by this block has its key in place
+		last = txn.Settle(last, b.SeqNum)
+		if err := txn.Validate(t, last, caKey, params.ChainID); err != nil {
+			return err
+		}
+		BlockDatabase[t.Email] = txn.Apply(last, t, b.SeqNum)
+	}
+	return nil
+}
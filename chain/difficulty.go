@@ -0,0 +1,112 @@
+package chain
+
+import (
+	"crypto/sha256"
+	"math/big"
+)
+
+// ChainParams are the consensus rules every node on a network has to agree
+// on. Difficulty is the expected number of hashes it takes to find a block:
+// a block hash, read as a big-endian number, must be at most
+// (2^256 - 1) / Difficulty.
+type ChainParams struct {
+	// names the network in every signature on it (see txn.SigningPayload),
+	// so a transaction signed for one network is no good on another
+	ChainID           string
+	InitialDifficulty uint64
+	MinDifficulty     uint64
+	// retarget every RetargetInterval blocks so blocks come about every
+	// TargetBlockTime seconds
+	RetargetInterval uint64
+	TargetBlockTime  int64
+	// never retarget; lets test networks run at a trivially low difficulty
+	PinDifficulty bool
+}
+
+var (
+	MainNetParams = ChainParams{
+		ChainID: "slykey-main",
+		// hashes start out needing NumZeros leading zero bits
+		InitialDifficulty: 1 << NumZeros,
+		MinDifficulty:     1 << 16,
+		RetargetInterval:  64,
+		TargetBlockTime:   60,
+	}
+	TestNetParams = ChainParams{
+		ChainID:           "slykey-test",
+		InitialDifficulty: 1,
+		MinDifficulty:     1,
+		RetargetInterval:  64,
+		TargetBlockTime:   1,
+		PinDifficulty:     true,
+	}
+
+	maxHash = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 256), big.NewInt(1))
+)
+
+// the largest hash that satisfies difficulty d
+func difficultyTarget(d uint64) *big.Int {
+	if d == 0 {
+		d = 1
+	}
+	return new(big.Int).Div(maxHash, new(big.Int).SetUint64(d))
+}
+
+func HashMeetsDifficulty(hash [sha256.Size]byte, d uint64) bool {
+	return new(big.Int).SetBytes(hash[:]).Cmp(difficultyTarget(d)) <= 0
+}
+
+// NextDifficulty is the difficulty the child of parent has to carry.
+// ancestor returns the block with the given SeqNum on parent's branch.
+//
+// Every RetargetInterval blocks the difficulty is scaled by how long the
+// last interval should have taken over how long it did (going by the block
+// timestamps), by at most a factor of 4 either way. Only integer math, so
+// every node comes out with the same answer.
+func (p *ChainParams) NextDifficulty(parent Block, ancestor func(seqNum uint64) Block) uint64 {
+	if parent.SeqNum == 0 {
+		return p.InitialDifficulty
+	}
+	child := parent.SeqNum + 1
+	if p.PinDifficulty || child%p.RetargetInterval != 0 {
+		return parent.Difficulty
+	}
+
+	// genesis has no real timestamp, so never start the window there
+	from := uint64(1)
+	if child > p.RetargetInterval {
+		from = child - p.RetargetInterval
+	}
+	first := ancestor(from)
+	if parent.SeqNum <= first.SeqNum {
+		return parent.Difficulty
+	}
+	expected := int64(parent.SeqNum-first.SeqNum) * p.TargetBlockTime
+	actual := parent.Timestamp - first.Timestamp
+	if actual < expected/4 {
+		actual = expected / 4
+	}
+	if actual > expected*4 {
+		actual = expected * 4
+	}
+	if actual <= 0 {
+		actual = 1
+	}
+
+	d := new(big.Int).SetUint64(parent.Difficulty)
+	d.Mul(d, big.NewInt(expected))
+	d.Div(d, big.NewInt(actual))
+	if !d.IsUint64() {
+		return ^uint64(0)
+	}
+	if d.Uint64() < p.MinDifficulty {
+		return p.MinDifficulty
+	}
+	return d.Uint64()
+}
+
+// BlockWork is the amount of work that went into a block: the expected
+// number of hashes it took to find
+func BlockWork(b Block) *big.Int {
+	return new(big.Int).SetUint64(b.Difficulty)
+}
@@ -0,0 +1,240 @@
+package chain
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"sort"
+
+	"github.com/tslilyai/SLYkey/txn"
+)
+
+// Directory is the email -> identity (key and sequence number) map,
+// authenticated by a sparse
+// Merkle tree. Every email sits at the leaf found by walking the bits of
+// sha256(email) from the top of a 256 level tree; the root commits to the
+// whole map, so one lookup can be proven present (or absent) against it.
+//
+// Empty subtrees hash to all zeros at every level, which keeps both the
+// tree and its proofs small.
+type Directory struct {
+	entries map[string]txn.Identity
+	root    *[sha256.Size]byte // cached, nil when stale
+}
+
+const (
+	smtDepth       = 256
+	smtLeafPrefix  = 0x00
+	smtInnerPrefix = 0x01
+)
+
+func NewDirectory() *Directory {
+	return &Directory{entries: make(map[string]txn.Identity)}
+}
+
+func (d *Directory) Get(email string) (txn.Identity, bool) {
+	id, ok := d.entries[email]
+	return id, ok
+}
+
+// Lookup is Get for callers that want nil for an unregistered email
+func (d *Directory) Lookup(email string) *txn.Identity {
+	if id, ok := d.entries[email]; ok {
+		return &id
+	}
+	return nil
+}
+
+func (d *Directory) Set(email string, id txn.Identity) {
+	d.entries[email] = id
+	d.root = nil
+}
+
+func (d *Directory) Delete(email string) {
+	delete(d.entries, email)
+	d.root = nil
+}
+
+func (d *Directory) Len() int {
+	return len(d.entries)
+}
+
+// calls fn on every entry, in no particular order
+func (d *Directory) ForEach(fn func(email string, id txn.Identity)) {
+	for email, id := range d.entries {
+		fn(email, id)
+	}
+}
+
+func (d *Directory) Copy() *Directory {
+	c := NewDirectory()
+	for email, id := range d.entries {
+		c.entries[email] = id
+	}
+	c.root = d.root
+	return c
+}
+
+// where email lives in the tree
+func smtPath(email string) [sha256.Size]byte {
+	return sha256.Sum256([]byte(email))
+}
+
+func smtBit(path [sha256.Size]byte, depth int) int {
+	return int(path[depth/8]>>(7-uint(depth%8))) & 1
+}
+
+// a leaf commits to the whole identity (see txn.Identity.Bytes), so a
+// proof shows the sequence number along with the key
+func smtLeaf(path [sha256.Size]byte, id *txn.Identity) [sha256.Size]byte {
+	kh := sha256.Sum256(id.Bytes())
+	buf := make([]byte, 0, 1+2*sha256.Size)
+	buf = append(buf, smtLeafPrefix)
+	buf = append(buf, path[:]...)
+	buf = append(buf, kh[:]...)
+	return sha256.Sum256(buf)
+}
+
+func smtInner(l [sha256.Size]byte, r [sha256.Size]byte) [sha256.Size]byte {
+	if l == ([sha256.Size]byte{}) && r == ([sha256.Size]byte{}) {
+		return [sha256.Size]byte{}
+	}
+	buf := make([]byte, 0, 1+2*sha256.Size)
+	buf = append(buf, smtInnerPrefix)
+	buf = append(buf, l[:]...)
+	buf = append(buf, r[:]...)
+	return sha256.Sum256(buf)
+}
+
+type smtEntry struct {
+	path [sha256.Size]byte
+	leaf [sha256.Size]byte
+}
+
+// entries sorted by path, so every subtree is a contiguous run
+func (d *Directory) sortedLeaves() []smtEntry {
+	leaves := make([]smtEntry, 0, len(d.entries))
+	for email, id := range d.entries {
+		id := id
+		path := smtPath(email)
+		leaves = append(leaves, smtEntry{path, smtLeaf(path, &id)})
+	}
+	sort.Slice(leaves, func(i, j int) bool {
+		return bytes.Compare(leaves[i].path[:], leaves[j].path[:]) < 0
+	})
+	return leaves
+}
+
+// hash of the subtree at depth holding exactly leaves
+func smtHash(leaves []smtEntry, depth int) [sha256.Size]byte {
+	if len(leaves) == 0 {
+		return [sha256.Size]byte{}
+	}
+	if depth == smtDepth {
+		return leaves[0].leaf
+	}
+	split := sort.Search(len(leaves), func(i int) bool {
+		return smtBit(leaves[i].path, depth) == 1
+	})
+	return smtInner(smtHash(leaves[:split], depth+1), smtHash(leaves[split:], depth+1))
+}
+
+// Root of the tree; the all-zero hash for an empty directory
+func (d *Directory) Root() [sha256.Size]byte {
+	if d.root == nil {
+		root := smtHash(d.sortedLeaves(), 0)
+		d.root = &root
+	}
+	return *d.root
+}
+
+// LookupProof shows what identity (if any) Email has under a directory root.
+// Siblings lists the non-empty sibling hashes from the leaf up; bit i of
+// NonEmpty says whether the sibling at depth smtDepth-1-i is one of them.
+type LookupProof struct {
+	Email    string
+	Found    bool
+	Identity txn.Identity
+	NonEmpty [smtDepth / 8]byte
+	Siblings [][sha256.Size]byte
+}
+
+// Prove looks up email and proves the answer, found or not
+func (d *Directory) Prove(email string) LookupProof {
+	p := LookupProof{Email: email}
+	p.Identity, p.Found = d.entries[email]
+	path := smtPath(email)
+
+	// walk down towards path, remembering the sibling subtree at each level
+	leaves := d.sortedLeaves()
+	siblings := make([][sha256.Size]byte, smtDepth)
+	for depth := 0; depth < smtDepth; depth++ {
+		split := sort.Search(len(leaves), func(i int) bool {
+			return smtBit(leaves[i].path, depth) == 1
+		})
+		if smtBit(path, depth) == 0 {
+			siblings[depth] = smtHash(leaves[split:], depth+1)
+			leaves = leaves[:split]
+		} else {
+			siblings[depth] = smtHash(leaves[:split], depth+1)
+			leaves = leaves[split:]
+		}
+	}
+	for i := 0; i < smtDepth; i++ {
+		sib := siblings[smtDepth-1-i]
+		if sib != ([sha256.Size]byte{}) {
+			p.NonEmpty[i/8] |= 1 << (7 - uint(i%8))
+			p.Siblings = append(p.Siblings, sib)
+		}
+	}
+	return p
+}
+
+// Verify checks the proof against a directory root
+func (p *LookupProof) Verify(root [sha256.Size]byte) error {
+	path := smtPath(p.Email)
+	node := [sha256.Size]byte{}
+	if p.Found {
+		node = smtLeaf(path, &p.Identity)
+	}
+	siblings := p.Siblings
+	for i := 0; i < smtDepth; i++ {
+		depth := smtDepth - 1 - i
+		sib := [sha256.Size]byte{}
+		if p.NonEmpty[i/8]&(1<<(7-uint(i%8))) != 0 {
+			if len(siblings) == 0 {
+				return fmt.Errorf("lookup proof too short")
+			}
+			sib = siblings[0]
+			siblings = siblings[1:]
+		}
+		if smtBit(path, depth) == 0 {
+			node = smtInner(node, sib)
+		} else {
+			node = smtInner(sib, node)
+		}
+	}
+	if len(siblings) != 0 {
+		return fmt.Errorf("lookup proof too long")
+	}
+	if node != root {
+		return fmt.Errorf("lookup proof does not match the directory root")
+	}
+	return nil
+}
+
+// KeyProof is a LookupProof tied to the block whose header commits to the
+// directory root it was made against
+type KeyProof struct {
+	Header BlockHeader
+	SeqNum uint64
+	Lookup LookupProof
+}
+
+// Verify checks the proof against nothing but the hash of the block
+func (kp *KeyProof) Verify(blockHash [sha256.Size]byte) error {
+	if kp.Header.Hash() != blockHash {
+		return fmt.Errorf("header does not hash to the block hash")
+	}
+	return kp.Lookup.Verify(kp.Header.StateRoot)
+}
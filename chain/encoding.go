@@ -0,0 +1,91 @@
+package chain
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/tslilyai/SLYkey/txn"
+)
+
+// the encoding of a header is just its fixed-size hashed bytes
+func (h BlockHeader) MarshalBinary() ([]byte, error) {
+	return h.Bytes(), nil
+}
+
+func (h *BlockHeader) UnmarshalBinary(data []byte) error {
+	if len(data) != HeaderSize {
+		return fmt.Errorf("header is %v bytes, not %v", len(data), HeaderSize)
+	}
+	h.Version = binary.BigEndian.Uint32(data[0:4])
+	data = data[4:]
+	copy(h.ParentHash[:], data)
+	data = data[len(h.ParentHash):]
+	copy(h.MerkleRoot[:], data)
+	data = data[len(h.MerkleRoot):]
+	copy(h.StateRoot[:], data)
+	data = data[len(h.StateRoot):]
+	h.Timestamp = int64(binary.BigEndian.Uint64(data[0:8]))
+	h.Difficulty = binary.BigEndian.Uint64(data[8:16])
+	h.Nonce = binary.BigEndian.Uint64(data[16:24])
+	return nil
+}
+
+// MarshalBinary is the canonical encoding of a block, used to store it and
+// send it to peers:
+//   [1 byte txn.EncodingVersion][HeaderSize byte header][8 byte SeqNum]
+//   [8 byte count] then per transaction [8 byte len][its encoding]
+// all big-endian. Hash isn't included since it is the header's hash.
+func (b Block) MarshalBinary() ([]byte, error) {
+	buf := make([]byte, 0, 1+HeaderSize+8+8)
+	buf = append(buf, txn.EncodingVersion)
+	buf = append(buf, b.BlockHeader.Bytes()...)
+	buf = binary.BigEndian.AppendUint64(buf, b.SeqNum)
+	buf = binary.BigEndian.AppendUint64(buf, uint64(len(b.Transactions)))
+	for i := range b.Transactions {
+		data, err := b.Transactions[i].MarshalBinary()
+		if err != nil {
+			return nil, fmt.Errorf("transaction %v: %v", i, err)
+		}
+		buf = binary.BigEndian.AppendUint64(buf, uint64(len(data)))
+		buf = append(buf, data...)
+	}
+	return buf, nil
+}
+
+func (b *Block) UnmarshalBinary(data []byte) error {
+	if len(data) < 1+HeaderSize+8+8 {
+		return fmt.Errorf("block truncated")
+	}
+	if data[0] != txn.EncodingVersion {
+		return fmt.Errorf("block encoding version %v, we only know %v", data[0], txn.EncodingVersion)
+	}
+	data = data[1:]
+	var blk Block
+	blk.BlockHeader.UnmarshalBinary(data[:HeaderSize])
+	data = data[HeaderSize:]
+	blk.SeqNum = binary.BigEndian.Uint64(data[0:8])
+	count := binary.BigEndian.Uint64(data[8:16])
+	data = data[16:]
+	for i := uint64(0); i < count; i++ {
+		if len(data) < 8 {
+			return fmt.Errorf("block truncated at transaction %v", i)
+		}
+		n := binary.BigEndian.Uint64(data[0:8])
+		data = data[8:]
+		if uint64(len(data)) < n {
+			return fmt.Errorf("block truncated at transaction %v", i)
+		}
+		var t txn.Transaction
+		if err := t.UnmarshalBinary(data[:n]); err != nil {
+			return fmt.Errorf("transaction %v: %v", i, err)
+		}
+		blk.Transactions = append(blk.Transactions, t)
+		data = data[n:]
+	}
+	if len(data) > 0 {
+		return fmt.Errorf("block: %v bytes left over", len(data))
+	}
+	blk.Hash = blk.GetHash()
+	*b = blk
+	return nil
+}
@@ -0,0 +1,77 @@
+package chain
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"sort"
+)
+
+const (
+	BlockVersion = 1
+	// a block's timestamp must be later than the median of this many
+	// blocks before it...
+	MedianTimeSpan = 11
+	// ...and no more than this many seconds ahead of our clock
+	MaxFutureDrift = 2 * 60 * 60
+	HeaderSize     = 4 + sha256.Size + sha256.Size + sha256.Size + 8 + 8 + 8
+)
+
+// BlockHeader is the part of a block that gets hashed (and mined). It
+// commits to the transactions through MerkleRoot and to the resulting
+// directory through StateRoot, so a header is enough to check proof of
+// work, chain linkage and key lookups without the block body.
+type BlockHeader struct {
+	Version    uint32
+	ParentHash [sha256.Size]byte
+	MerkleRoot [sha256.Size]byte
+	StateRoot  [sha256.Size]byte // Directory root after applying this block
+	Timestamp  int64             // unix seconds, set by the miner
+	Difficulty uint64            // see ChainParams
+	Nonce      uint64
+}
+
+// the fixed-size big-endian encoding we hash
+func (h *BlockHeader) Bytes() []byte {
+	buf := make([]byte, 0, HeaderSize)
+	buf = binary.BigEndian.AppendUint32(buf, h.Version)
+	buf = append(buf, h.ParentHash[:]...)
+	buf = append(buf, h.MerkleRoot[:]...)
+	buf = append(buf, h.StateRoot[:]...)
+	buf = binary.BigEndian.AppendUint64(buf, uint64(h.Timestamp))
+	buf = binary.BigEndian.AppendUint64(buf, h.Difficulty)
+	buf = binary.BigEndian.AppendUint64(buf, h.Nonce)
+	return buf
+}
+
+func (h *BlockHeader) Hash() [sha256.Size]byte {
+	return sha256.Sum256(h.Bytes())
+}
+
+// MedianTimePast is the median timestamp of the MedianTimeSpan blocks
+// ending at parent. ancestor returns the block with the given SeqNum on
+// parent's branch.
+func MedianTimePast(parent Block, ancestor func(seqNum uint64) Block) int64 {
+	var times []int64
+	for seq := parent.SeqNum; len(times) < MedianTimeSpan; seq-- {
+		if seq == 0 {
+			// genesis has no real timestamp
+			break
+		}
+		if seq == parent.SeqNum {
+			times = append(times, parent.Timestamp)
+		} else {
+			times = append(times, ancestor(seq).Timestamp)
+		}
+	}
+	if len(times) == 0 {
+		return 0
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i] < times[j] })
+	return times[len(times)/2]
+}
+
+// CheckTimestamp checks a block's timestamp against the median time past of
+// the blocks before it and our clock
+func CheckTimestamp(b Block, mtp int64, now int64) bool {
+	return b.Timestamp > mtp && b.Timestamp <= now+MaxFutureDrift
+}
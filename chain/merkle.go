@@ -0,0 +1,156 @@
+package chain
+
+import (
+	"crypto/sha256"
+	"fmt"
+
+	"github.com/tslilyai/SLYkey/txn"
+)
+
+// leaves and inner nodes are hashed with different prefixes so an inner
+// node can never pass for a transaction
+const (
+	merkleLeafPrefix  = 0x00
+	merkleInnerPrefix = 0x01
+)
+
+// a leaf hashes the transaction's encoding (see Transaction.MarshalBinary)
+func merkleLeaf(t *txn.Transaction) [sha256.Size]byte {
+	data, _ := t.MarshalBinary()
+	return sha256.Sum256(append([]byte{merkleLeafPrefix}, data...))
+}
+
+func merkleInner(l [sha256.Size]byte, r [sha256.Size]byte) [sha256.Size]byte {
+	buf := make([]byte, 0, 1+2*sha256.Size)
+	buf = append(buf, merkleInnerPrefix)
+	buf = append(buf, l[:]...)
+	buf = append(buf, r[:]...)
+	return sha256.Sum256(buf)
+}
+
+// MerkleRoot of a list of transactions. An odd node out at any level is
+// carried up as is rather than paired with itself, so two different lists
+// never share a root. The empty list has the all-zero root.
+func MerkleRoot(txns []txn.Transaction) [sha256.Size]byte {
+	if len(txns) == 0 {
+		return [sha256.Size]byte{}
+	}
+	level := make([][sha256.Size]byte, len(txns))
+	for i := range txns {
+		level[i] = merkleLeaf(&txns[i])
+	}
+	for len(level) > 1 {
+		var next [][sha256.Size]byte
+		for i := 0; i < len(level); i += 2 {
+			if i+1 < len(level) {
+				next = append(next, merkleInner(level[i], level[i+1]))
+			} else {
+				next = append(next, level[i])
+			}
+		}
+		level = next
+	}
+	return level[0]
+}
+
+// MerkleProof shows that a leaf sits at Index among NumLeaves leaves under
+// some root: Siblings are the hashes to combine with, bottom up, skipping
+// the levels where the node is the odd one out and carried up.
+type MerkleProof struct {
+	Index     uint64
+	NumLeaves uint64
+	Siblings  [][sha256.Size]byte
+}
+
+// MerkleProve builds the proof for txns[index]
+func MerkleProve(txns []txn.Transaction, index int) MerkleProof {
+	proof := MerkleProof{Index: uint64(index), NumLeaves: uint64(len(txns))}
+	level := make([][sha256.Size]byte, len(txns))
+	for i := range txns {
+		level[i] = merkleLeaf(&txns[i])
+	}
+	for len(level) > 1 {
+		if sib := index ^ 1; sib < len(level) {
+			proof.Siblings = append(proof.Siblings, level[sib])
+		}
+		var next [][sha256.Size]byte
+		for i := 0; i < len(level); i += 2 {
+			if i+1 < len(level) {
+				next = append(next, merkleInner(level[i], level[i+1]))
+			} else {
+				next = append(next, level[i])
+			}
+		}
+		level = next
+		index /= 2
+	}
+	return proof
+}
+
+// Root recomputes the root the proof leads to from leaf
+func (p *MerkleProof) Root(leaf [sha256.Size]byte) ([sha256.Size]byte, error) {
+	if p.Index >= p.NumLeaves {
+		return [sha256.Size]byte{}, fmt.Errorf("leaf index %v out of range", p.Index)
+	}
+	node := leaf
+	index, width := p.Index, p.NumLeaves
+	siblings := p.Siblings
+	for width > 1 {
+		if sib := index ^ 1; sib < width {
+			if len(siblings) == 0 {
+				return [sha256.Size]byte{}, fmt.Errorf("merkle proof too short")
+			}
+			if index%2 == 0 {
+				node = merkleInner(node, siblings[0])
+			} else {
+				node = merkleInner(siblings[0], node)
+			}
+			siblings = siblings[1:]
+		}
+		index /= 2
+		width = (width + 1) / 2
+	}
+	if len(siblings) != 0 {
+		return [sha256.Size]byte{}, fmt.Errorf("merkle proof too long")
+	}
+	return node, nil
+}
+
+// TxnProof shows that Transaction is in the block whose header is Header
+type TxnProof struct {
+	Header      BlockHeader
+	SeqNum      uint64
+	Transaction txn.Transaction
+	Proof       MerkleProof
+}
+
+// ProveTransaction finds the last transaction for email in b (the one that
+// took effect) and proves it is in there
+func ProveTransaction(b *Block, email string) (TxnProof, error) {
+	for i := len(b.Transactions) - 1; i >= 0; i-- {
+		if b.Transactions[i].Email == email {
+			return TxnProof{
+				Header:      b.BlockHeader,
+				SeqNum:      b.SeqNum,
+				Transaction: b.Transactions[i],
+				Proof:       MerkleProve(b.Transactions, i),
+			}, nil
+		}
+	}
+	return TxnProof{}, fmt.Errorf("no transaction for %v in block %v", email, b.SeqNum)
+}
+
+// Verify checks the proof against nothing but the hash of the block
+func (tp *TxnProof) Verify(blockHash [sha256.Size]byte) error {
+	if tp.Header.Hash() != blockHash {
+		return fmt.Errorf("header does not hash to the block hash")
+	}
+	root, err := tp.Proof.Root(merkleLeaf(&tp.Transaction))
+	if err != nil {
+		return err
+	}
+	if root != tp.Header.MerkleRoot {
+		return fmt.Errorf("transaction is not under the block's merkle root")
+	}
+	return nil
+}
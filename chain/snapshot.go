@@ -0,0 +1,276 @@
+package chain
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/tslilyai/SLYkey/txn"
+)
+
+const (
+	// take a snapshot of the directory every SnapshotInterval blocks
+	SnapshotInterval = 100
+	// how many old snapshots to keep around
+	SnapshotsKept = 3
+)
+
+// Snapshot is the directory state as of block SeqNum (whose hash is Hash).
+// Digest seals the rest of the fields; a snapshot whose digest doesn't
+// match its contents is ignored.
+//
+// The digest is a plain hash, not a signature: it catches a torn or
+// corrupted file, not one someone rewrote on purpose. Snapshots never leave
+// the node that made them, and what makes one safe to boot from is the
+// check in Latest that its directory hashes to the StateRoot of its block
+// on our chain, so a forged one is skipped like a corrupt one.
+type Snapshot struct {
+	SeqNum  uint64
+	Hash    [sha256.Size]byte
+	Entries map[string]txn.Identity
+	Digest  [sha256.Size]byte
+}
+
+func NewSnapshot(b Block, db *Directory) *Snapshot {
+	s := &Snapshot{
+		SeqNum:  b.SeqNum,
+		Hash:    b.Hash,
+		Entries: make(map[string]txn.Identity, db.Len()),
+	}
+	db.ForEach(func(email string, id txn.Identity) {
+		s.Entries[email] = id
+	})
+	s.Digest = s.digest()
+	return s
+}
+
+// hash over seqnum, block hash and the entries in email order
+func (s *Snapshot) digest() [sha256.Size]byte {
+	h := sha256.New()
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, s.SeqNum)
+	h.Write(buf)
+	h.Write(s.Hash[:])
+
+	emails := make([]string, 0, len(s.Entries))
+	for email := range s.Entries {
+		emails = append(emails, email)
+	}
+	sort.Strings(emails)
+	for _, email := range emails {
+		id := s.Entries[email]
+		writeLenPrefixed(h, []byte(email))
+		writeLenPrefixed(h, id.Bytes())
+	}
+	var sum [sha256.Size]byte
+	copy(sum[:], h.Sum(nil))
+	return sum
+}
+
+func writeLenPrefixed(h io.Writer, data []byte) {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, uint64(len(data)))
+	h.Write(buf)
+	h.Write(data)
+}
+
+// the directory the snapshot holds
+func (s *Snapshot) Directory() *Directory {
+	db := NewDirectory()
+	for email, id := range s.Entries {
+		db.Set(email, id)
+	}
+	return db
+}
+
+// Equal reports whether two snapshots describe the same state
+func (s *Snapshot) Equal(o *Snapshot) bool {
+	return s.digest() == o.digest()
+}
+
+// SnapshotStore keeps snapshots as files in a directory, one per SeqNum
+type SnapshotStore struct {
+	dir string
+}
+
+func OpenSnapshotStore(dir string) (*SnapshotStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &SnapshotStore{dir: dir}, nil
+}
+
+func (ss *SnapshotStore) fileName(seqNum uint64) string {
+	return filepath.Join(ss.dir, fmt.Sprintf("snap-%020d.json", seqNum))
+}
+
+// Write durably stores s: write to a temp file, fsync, then rename it into
+// place so a crash never leaves a half-written snapshot under a real name.
+func (ss *SnapshotStore) Write(s *Snapshot) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(ss.dir, "tmp-snap-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), ss.fileName(s.SeqNum)); err != nil {
+		return err
+	}
+	// make the rename itself durable
+	if d, err := os.Open(ss.dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	ss.prune()
+	return nil
+}
+
+// SeqNums of all snapshots on disk, newest first
+func (ss *SnapshotStore) list() []uint64 {
+	names, _ := filepath.Glob(filepath.Join(ss.dir, "snap-*.json"))
+	var seqs []uint64
+	for _, name := range names {
+		var seq uint64
+		base := strings.TrimSuffix(filepath.Base(name), ".json")
+		if _, err := fmt.Sscanf(base, "snap-%d", &seq); err == nil {
+			seqs = append(seqs, seq)
+		}
+	}
+	sort.Slice(seqs, func(i, j int) bool { return seqs[i] > seqs[j] })
+	return seqs
+}
+
+func (ss *SnapshotStore) prune() {
+	seqs := ss.list()
+	for i := SnapshotsKept; i < len(seqs); i++ {
+		os.Remove(ss.fileName(seqs[i]))
+	}
+}
+
+func (ss *SnapshotStore) Read(seqNum uint64) (*Snapshot, error) {
+	data, err := ioutil.ReadFile(ss.fileName(seqNum))
+	if err != nil {
+		return nil, err
+	}
+	s := &Snapshot{}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, err
+	}
+	if s.SeqNum != seqNum || s.digest() != s.Digest {
+		return nil, fmt.Errorf("snapshot %v is corrupt", seqNum)
+	}
+	return s, nil
+}
+
+// Latest returns the newest intact snapshot at or below maxSeq that is
+// still part of chain and matches the state root of its block there, or nil
+// if there isn't one
+func (ss *SnapshotStore) Latest(chain BlockStore, maxSeq uint64) *Snapshot {
+	for _, seq := range ss.list() {
+		if seq > maxSeq {
+			continue
+		}
+		s, err := ss.Read(seq)
+		if err != nil {
+			continue
+		}
+		// the chain may have been reorganised past this snapshot, and the
+		// block tells us what the directory should hash to
+		b, ok := chain.Get(s.SeqNum)
+		if ok && b.Hash == s.Hash && s.Directory().Root() == b.StateRoot {
+			return s
+		}
+	}
+	return nil
+}
+
+// LoadDirectory rebuilds the directory at the tip of chain from the newest
+// usable snapshot, replaying the blocks after it. With no snapshot it
+// replays from genesis.
+func LoadDirectory(snaps *SnapshotStore, chain BlockStore) *Directory {
+	return LoadDirectoryAt(snaps, chain, chain.Tip().SeqNum)
+}
+
+// LoadDirectoryAt is LoadDirectory, but stops at block upTo
+func LoadDirectoryAt(snaps *SnapshotStore, chain BlockStore, upTo uint64) *Directory {
+	db := NewDirectory()
+	from := uint64(0)
+	if snaps != nil {
+		if s := snaps.Latest(chain, upTo); s != nil {
+			db = s.Directory()
+			from = s.SeqNum + 1
+		}
+	}
+	chain.ForEach(func(b Block) bool {
+		if b.SeqNum > upTo {
+			return false
+		}
+		if b.SeqNum >= from {
+			ApplyBlock(db, &b)
+		}
+		return true
+	})
+	return db
+}
+
+// VerifySnapshot recomputes the directory state from genesis up to s.SeqNum
+// and checks that s matches it
+func VerifySnapshot(s *Snapshot, chain BlockStore) error {
+	if s.digest() != s.Digest {
+		return fmt.Errorf("snapshot %v: bad digest", s.SeqNum)
+	}
+	b, ok := chain.Get(s.SeqNum)
+	if !ok || b.Hash != s.Hash {
+		return fmt.Errorf("snapshot %v: block is not in our chain", s.SeqNum)
+	}
+	if s.Directory().Root() != b.StateRoot {
+		return fmt.Errorf("snapshot %v: does not match the block's state root", s.SeqNum)
+	}
+	db := NewDirectory()
+	chain.ForEach(func(b Block) bool {
+		if b.SeqNum > s.SeqNum {
+			return false
+		}
+		ApplyBlock(db, &b)
+		return true
+	})
+	if !s.Equal(NewSnapshot(b, db)) {
+		return fmt.Errorf("snapshot %v: does not match the chain", s.SeqNum)
+	}
+	return nil
+}
+
+// VerifyAll runs VerifySnapshot on every snapshot in the store
+func (ss *SnapshotStore) VerifyAll(chain BlockStore) error {
+	for _, seq := range ss.list() {
+		s, err := ss.Read(seq)
+		if err != nil {
+			return err
+		}
+		if err := VerifySnapshot(s, chain); err != nil {
+			return err
+		}
+	}
+	return nil
+}
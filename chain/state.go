@@ -0,0 +1,35 @@
+package chain
+
+import (
+	"github.com/tslilyai/SLYkey/txn"
+)
+
+// Undo is what it takes to roll a block back: the old identity of every
+// email the block touched (nil if it wasn't registered)
+type Undo map[string]*txn.Identity
+
+// ApplyBlock applies the transactions of b to db and returns its Undo
+func ApplyBlock(db *Directory, b *Block) Undo {
+	undo := make(Undo)
+	// we should have already checked if txn and signatures are valid
+	for i := range b.Transactions {
+		t := &b.Transactions[i]
+		last := db.Lookup(t.Email)
+		if _, seen := undo[t.Email]; !seen {
+			undo[t.Email] = last
+		}
+		db.Set(t.Email, txn.Apply(txn.Settle(last, b.SeqNum), t, b.SeqNum))
+	}
+	return undo
+}
+
+// UndoBlock rolls db back over a block applied with ApplyBlock
+func UndoBlock(db *Directory, undo Undo) {
+	for email, old := range undo {
+		if old == nil {
+			db.Delete(email)
+		} else {
+			db.Set(email, *old)
+		}
+	}
+}
@@ -0,0 +1,361 @@
+package chain
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"sync"
+)
+
+// BlockStore holds a node's copy of the block chain, indexed both by
+// SeqNum and by block hash. Putting block n makes it the tip: an old block
+// at n and anything above it are dropped (this is how reorgs get written).
+type BlockStore interface {
+	Put(b Block) error
+	Get(seqNum uint64) (Block, bool)
+	GetByHash(hash [sha256.Size]byte) (Block, bool)
+	// the block with the highest SeqNum
+	Tip() Block
+	// calls fn on every block in SeqNum order until fn returns false
+	ForEach(fn func(b Block) bool)
+	Close() error
+}
+
+// in-memory store, mostly for tests
+type MemBlockStore struct {
+	mu     sync.RWMutex
+	blocks map[uint64]Block
+	byHash map[[sha256.Size]byte]uint64
+	tip    uint64
+}
+
+// NewMemBlockStore returns an empty store holding only genesis, the dummy
+// block 0 (see ChainParams.Genesis)
+func NewMemBlockStore(genesis Block) *MemBlockStore {
+	s := newMemBlockStore()
+	s.put(genesis)
+	return s
+}
+
+func newMemBlockStore() *MemBlockStore {
+	return &MemBlockStore{
+		blocks: make(map[uint64]Block),
+		byHash: make(map[[sha256.Size]byte]uint64),
+	}
+}
+
+// Precondition: mu acquired
+func (s *MemBlockStore) put(b Block) {
+	s.cut(b.SeqNum)
+	s.blocks[b.SeqNum] = b
+	s.byHash[b.Hash] = b.SeqNum
+	s.tip = b.SeqNum
+}
+
+// Precondition: mu acquired
+// drops the blocks from seqNum up to the tip
+func (s *MemBlockStore) cut(seqNum uint64) {
+	for seq := seqNum; seq <= s.tip; seq++ {
+		if old, ok := s.blocks[seq]; ok {
+			delete(s.byHash, old.Hash)
+			delete(s.blocks, seq)
+		}
+	}
+	if seqNum > 0 && seqNum <= s.tip {
+		s.tip = seqNum - 1
+	}
+}
+
+func (s *MemBlockStore) Put(b Block) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.put(b)
+	return nil
+}
+
+func (s *MemBlockStore) Get(seqNum uint64) (Block, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	b, ok := s.blocks[seqNum]
+	return b, ok
+}
+
+func (s *MemBlockStore) GetByHash(hash [sha256.Size]byte) (Block, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	seq, ok := s.byHash[hash]
+	if !ok {
+		return Block{}, false
+	}
+	return s.blocks[seq], true
+}
+
+func (s *MemBlockStore) Tip() Block {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.blocks[s.tip]
+}
+
+func (s *MemBlockStore) ForEach(fn func(b Block) bool) {
+	s.mu.RLock()
+	tip := s.tip
+	s.mu.RUnlock()
+	for seq := uint64(0); seq <= tip; seq++ {
+		b, ok := s.Get(seq)
+		if ok && !fn(b) {
+			return
+		}
+	}
+}
+
+func (s *MemBlockStore) Close() error {
+	return nil
+}
+
+// FileBlockStore is a log of blocks on disk, appended to as the chain
+// grows. Every record is
+//   [4 byte length][4 byte crc32 of payload][payload]
+// where the payload is the block's encoding (see Block.MarshalBinary), and
+// is fsynced before Put returns. On open the log is replayed into an
+// in-memory index; a torn or corrupt record at the end (from a crash
+// mid-write) is cut off, since it was never acknowledged.
+//
+// The log holds just the chain, in SeqNum order: a Put that replaces
+// blocks (a reorg) first cuts them off the end of the log. A log that
+// still has replaced blocks in it is compacted when it is opened.
+type FileBlockStore struct {
+	*MemBlockStore
+	wMu     sync.Mutex // serializes writes to f, offsets and end
+	f       *os.File
+	offsets map[uint64]int64 // where each block's record starts
+	end     int64            // offset just past the last good record
+	path    string
+}
+
+const (
+	recordHeaderSize = 8
+	// the largest block record we write, or believe a length prefix about
+	MaxBlockRecord = 64 << 20
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// OpenFileBlockStore opens (or creates) the block log at path, for the
+// chain starting at genesis
+func OpenFileBlockStore(path string, genesis Block) (*FileBlockStore, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	s := &FileBlockStore{
+		MemBlockStore: newMemBlockStore(),
+		f:             f,
+		offsets:       make(map[uint64]int64),
+		path:          path,
+	}
+	good, replaced, err := s.replay()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if replaced {
+		err = s.compact()
+	} else {
+		// drop whatever follows the last good record
+		err = s.truncate(good)
+	}
+	if err != nil {
+		s.f.Close()
+		return nil, err
+	}
+	if g, ok := s.Get(0); !ok {
+		if err := s.Put(genesis); err != nil {
+			s.f.Close()
+			return nil, err
+		}
+	} else if g.Hash != genesis.Hash {
+		s.f.Close()
+		return nil, fmt.Errorf("%v holds a chain of another network", path)
+	}
+	return s, nil
+}
+
+// reads records from the start of the file, returning the offset just
+// past the last intact one, and whether any block in the log was replaced
+// by a later one
+func (s *FileBlockStore) replay() (int64, bool, error) {
+	info, err := s.f.Stat()
+	if err != nil {
+		return 0, false, err
+	}
+	if _, err := s.f.Seek(0, io.SeekStart); err != nil {
+		return 0, false, err
+	}
+	r := bufio.NewReader(s.f)
+	hdr := make([]byte, recordHeaderSize)
+	good := int64(0)
+	replaced := false
+	for {
+		if _, err := io.ReadFull(r, hdr); err != nil {
+			return good, replaced, nil
+		}
+		size := binary.BigEndian.Uint32(hdr[0:4])
+		sum := binary.BigEndian.Uint32(hdr[4:8])
+		// a length running past the end of the file, or past anything we
+		// would have written, is a torn or garbled header; don't allocate
+		// for it
+		if size > MaxBlockRecord || int64(size) > info.Size()-good-recordHeaderSize {
+			return good, replaced, nil
+		}
+		payload := make([]byte, size)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			return good, replaced, nil
+		}
+		if crc32.Checksum(payload, crcTable) != sum {
+			return good, replaced, nil
+		}
+		var b Block
+		if err := b.UnmarshalBinary(payload); err != nil {
+			// an intact record we can't read isn't a torn write; don't
+			// cut it (and everything after it) off
+			return 0, false, fmt.Errorf("%v: block record at %v: %v", s.path, good, err)
+		}
+		if _, ok := s.MemBlockStore.blocks[b.SeqNum]; ok || b.SeqNum < s.MemBlockStore.tip {
+			replaced = true
+		}
+		s.MemBlockStore.put(b)
+		s.offsets[b.SeqNum] = good
+		good += recordHeaderSize + int64(size)
+	}
+}
+
+// the record for b, ready to write
+func encodeRecord(b Block) ([]byte, error) {
+	payload, err := b.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	if len(payload) > MaxBlockRecord {
+		return nil, fmt.Errorf("block %v is %v bytes encoded, more than the %v a record holds", b.SeqNum, len(payload), MaxBlockRecord)
+	}
+	rec := make([]byte, recordHeaderSize, recordHeaderSize+len(payload))
+	binary.BigEndian.PutUint32(rec[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(rec[4:8], crc32.Checksum(payload, crcTable))
+	return append(rec, payload...), nil
+}
+
+// Precondition: wMu acquired, or not shared yet
+// cuts the log off at end, forgetting the records past it
+func (s *FileBlockStore) truncate(end int64) error {
+	if err := s.f.Truncate(end); err != nil {
+		return err
+	}
+	if _, err := s.f.Seek(end, io.SeekStart); err != nil {
+		return err
+	}
+	for seq, off := range s.offsets {
+		if off >= end {
+			delete(s.offsets, seq)
+		}
+	}
+	s.end = end
+	return nil
+}
+
+// Precondition: not shared yet
+// compact rewrites the log with just the chain's blocks, leaving out the
+// ones reorgs replaced. The new log is written beside the old one and
+// renamed over it, so a crash leaves one or the other.
+func (s *FileBlockStore) compact() error {
+	tmp := s.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	offsets := make(map[uint64]int64)
+	end := int64(0)
+	w := bufio.NewWriter(f)
+	s.MemBlockStore.ForEach(func(b Block) bool {
+		var rec []byte
+		if rec, err = encodeRecord(b); err != nil {
+			return false
+		}
+		if _, err = w.Write(rec); err != nil {
+			return false
+		}
+		offsets[b.SeqNum] = end
+		end += int64(len(rec))
+		return true
+	})
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if err == nil {
+		err = os.Rename(tmp, s.path)
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return fmt.Errorf("compacting %v: %v", s.path, err)
+	}
+	s.f.Close()
+	s.f, s.offsets, s.end = f, offsets, end
+	_, err = f.Seek(end, io.SeekStart)
+	return err
+}
+
+func (s *FileBlockStore) Put(b Block) error {
+	rec, err := encodeRecord(b)
+	if err != nil {
+		return err
+	}
+
+	s.wMu.Lock()
+	defer s.wMu.Unlock()
+	// the blocks b replaces go, so cut them off the log rather than leave
+	// them for every replay to read past
+	at := s.end
+	for seq, off := range s.offsets {
+		if seq >= b.SeqNum && off < at {
+			at = off
+		}
+	}
+	cut := at < s.end
+	if cut {
+		if err := s.truncate(at); err != nil {
+			return fmt.Errorf("block store %v: %v", s.path, err)
+		}
+	}
+	if _, err = s.f.Write(rec); err == nil {
+		err = s.f.Sync()
+	}
+	if err != nil {
+		// don't leave half a record behind for the next append to follow
+		s.truncate(s.end)
+		if cut {
+			// the replaced blocks are gone from disk, so they go from
+			// memory too
+			s.MemBlockStore.mu.Lock()
+			s.MemBlockStore.cut(b.SeqNum)
+			s.MemBlockStore.mu.Unlock()
+		}
+		return fmt.Errorf("block store %v: %v", s.path, err)
+	}
+	s.offsets[b.SeqNum] = s.end
+	s.end += int64(len(rec))
+	// only visible once it is durable
+	return s.MemBlockStore.Put(b)
+}
+
+func (s *FileBlockStore) Close() error {
+	s.wMu.Lock()
+	defer s.wMu.Unlock()
+	return s.f.Close()
+}
@@ -0,0 +1,182 @@
+package chain
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// a child of parent; tag tells siblings apart
+func testBlock(parent Block, tag int64) Block {
+	b := Block{SeqNum: parent.SeqNum + 1}
+	b.Version = BlockVersion
+	b.ParentHash = parent.Hash
+	b.Timestamp = tag
+	b.Hash = b.BlockHeader.Hash()
+	return b
+}
+
+func fileSize(t *testing.T, path string) int64 {
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info.Size()
+}
+
+func recordSize(t *testing.T, b Block) int64 {
+	rec, err := encodeRecord(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return int64(len(rec))
+}
+
+func TestStoreIgnoresBogusLength(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "blocks.log")
+	genesis := TestNetParams.Genesis()
+	s, err := OpenFileBlockStore(path, genesis)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b1 := testBlock(genesis, 1)
+	if err := s.Put(b1); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+	size := fileSize(t, path)
+
+	// a header promising far more than the file holds
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hdr := make([]byte, recordHeaderSize)
+	binary.BigEndian.PutUint32(hdr[0:4], 0xfffffff0)
+	f.Write(hdr)
+	f.Close()
+
+	s, err = OpenFileBlockStore(path, genesis)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if tip := s.Tip(); tip.Hash != b1.Hash {
+		t.Fatalf("tip is block %v, not 1", tip.SeqNum)
+	}
+	if got := fileSize(t, path); got != size {
+		t.Fatalf("log is %v bytes, not the %v before the bogus header", got, size)
+	}
+}
+
+func TestStoreReorgCutsLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "blocks.log")
+	genesis := TestNetParams.Genesis()
+	s, err := OpenFileBlockStore(path, genesis)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b1 := testBlock(genesis, 1)
+	b2 := testBlock(b1, 2)
+	b3 := testBlock(b2, 3)
+	for _, b := range []Block{b1, b2, b3} {
+		if err := s.Put(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fork := testBlock(b1, 4)
+	if err := s.Put(fork); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	want := recordSize(t, genesis) + recordSize(t, b1) + recordSize(t, fork)
+	if got := fileSize(t, path); got != want {
+		t.Fatalf("log is %v bytes after the reorg, want %v", got, want)
+	}
+	s, err = OpenFileBlockStore(path, genesis)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if tip := s.Tip(); tip.Hash != fork.Hash {
+		t.Fatalf("tip is block %v, not the fork", tip.SeqNum)
+	}
+	if _, ok := s.GetByHash(b3.Hash); ok {
+		t.Fatal("block 3 came back")
+	}
+}
+
+// logs written before reorgs cut them still have the replaced blocks in
+func TestStoreCompactsOnOpen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "blocks.log")
+	genesis := TestNetParams.Genesis()
+	b1 := testBlock(genesis, 1)
+	b2 := testBlock(b1, 2)
+	fork := testBlock(b1, 3)
+	var log []byte
+	for _, b := range []Block{genesis, b1, b2, fork} {
+		rec, err := encodeRecord(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		log = append(log, rec...)
+	}
+	if err := ioutil.WriteFile(path, log, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := OpenFileBlockStore(path, genesis)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	want := recordSize(t, genesis) + recordSize(t, b1) + recordSize(t, fork)
+	if got := fileSize(t, path); got != want {
+		t.Fatalf("log is %v bytes after opening, want %v", got, want)
+	}
+	if tip := s.Tip(); tip.Hash != fork.Hash {
+		t.Fatalf("tip is block %v, not the fork", tip.SeqNum)
+	}
+	// and it keeps working
+	b3 := testBlock(fork, 4)
+	if err := s.Put(b3); err != nil {
+		t.Fatal(err)
+	}
+	if got := fileSize(t, path); got != want+recordSize(t, b3) {
+		t.Fatalf("log is %v bytes after another block, want %v", got, want+recordSize(t, b3))
+	}
+}
+
+// a log of another network's chain is refused, even one that needed
+// compacting first, and is left as it was
+func TestStoreRefusesOtherNetwork(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "blocks.log")
+	genesis := TestNetParams.Genesis()
+	b1 := testBlock(genesis, 1)
+	var log []byte
+	for _, b := range []Block{genesis, b1, testBlock(genesis, 2)} {
+		rec, err := encodeRecord(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		log = append(log, rec...)
+	}
+	if err := ioutil.WriteFile(path, log, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenFileBlockStore(path, MainNetParams.Genesis()); err == nil {
+		t.Fatal("opened a test network log as a main network one")
+	}
+	// what it compacted on the way is still a good log of its own network
+	s, err := OpenFileBlockStore(path, genesis)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if tip := s.Tip(); tip.SeqNum != 1 || tip.Hash == b1.Hash {
+		t.Fatalf("tip is block %v, not the second block 1", tip.SeqNum)
+	}
+}
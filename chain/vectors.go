@@ -0,0 +1,252 @@
+package chain
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+
+	"github.com/tslilyai/SLYkey/txn"
+)
+
+// Vectors are worked examples of the encodings of transactions and blocks
+// (see Transaction.MarshalBinary and Block.MarshalBinary) and the hashes
+// computed over them, for other implementations to check themselves
+// against. The golden copy is chain/testdata/vectors.json, which the
+// package's tests check against this code (and rewrite with -update);
+// `slykey vectors` checks a copy of it. All byte strings are hex. Signing
+// payloads are for the network ChainID.
+type Vectors struct {
+	EncodingVersion int             `json:"encoding_version"`
+	ChainID         string          `json:"chain_id"`
+	Transactions    []TxnVector     `json:"transactions"`
+	Blocks          []BlockVector   `json:"blocks"`
+	Invalid         []InvalidVector `json:"invalid"`
+}
+
+// a transaction's fields, then what they encode and hash to
+type TxnVector struct {
+	Name        string        `json:"name"`
+	Type        txn.TransType `json:"type"`
+	Email       string        `json:"email"`
+	N           hexBytes      `json:"n"`
+	E           int           `json:"e"`
+	Sequence    uint64        `json:"sequence"`
+	PrevKeyHash hexBytes      `json:"prev_key_hash,omitempty"` // all zeros if empty
+	Nonce       hexBytes      `json:"nonce,omitempty"`
+	// registrations with a recovery key only
+	RecoveryN     hexBytes `json:"recovery_n,omitempty"`
+	RecoveryE     int      `json:"recovery_e,omitempty"`
+	RecoveryDelay uint64   `json:"recovery_delay,omitempty"`
+	// adding and removing keys only
+	Label     string      `json:"label,omitempty"`
+	Purpose   txn.Purpose `json:"purpose,omitempty"`
+	Signature hexBytes    `json:"signature"`
+
+	Encoding hexBytes `json:"encoding"`
+	Hash     hexBytes `json:"hash"` // Transaction.Hash
+	// see txn.SigningPayload; empty for types nobody signs
+	SigningPayload hexBytes `json:"signing_payload,omitempty"`
+	SigHash        hexBytes `json:"sig_hash,omitempty"`
+}
+
+// a block's header fields, SeqNum and transactions (by TxnVector name),
+// then its Merkle root, encoding and hash
+type BlockVector struct {
+	Name         string   `json:"name"`
+	Version      uint32   `json:"version"`
+	ParentHash   hexBytes `json:"parent_hash"`
+	StateRoot    hexBytes `json:"state_root"`
+	Timestamp    int64    `json:"timestamp"`
+	Difficulty   uint64   `json:"difficulty"`
+	Nonce        uint64   `json:"nonce"`
+	SeqNum       uint64   `json:"seq_num"`
+	Transactions []string `json:"transactions"`
+
+	MerkleRoot hexBytes `json:"merkle_root"`
+	Encoding   hexBytes `json:"encoding"`
+	Hash       hexBytes `json:"hash"`
+}
+
+// an encoding that must not decode, because it isn't canonical or isn't
+// whole. Kind is "transaction" or "block".
+type InvalidVector struct {
+	Name     string   `json:"name"`
+	Kind     string   `json:"kind"`
+	Encoding hexBytes `json:"encoding"`
+}
+
+type hexBytes []byte
+
+func (h hexBytes) MarshalText() ([]byte, error) {
+	return []byte(hex.EncodeToString(h)), nil
+}
+
+func (h *hexBytes) UnmarshalText(text []byte) error {
+	b, err := hex.DecodeString(string(text))
+	*h = b
+	return err
+}
+
+func (v *TxnVector) transaction() txn.Transaction {
+	t := txn.Transaction{
+		Type:      v.Type,
+		Email:     v.Email,
+		PublicKey: rsa.PublicKey{E: v.E},
+		Sequence:  v.Sequence,
+		Label:     v.Label,
+		Purpose:   v.Purpose,
+		Signature: v.Signature,
+	}
+	if len(v.N) > 0 {
+		t.PublicKey.N = new(big.Int).SetBytes(v.N)
+	}
+	copy(t.PrevKeyHash[:], v.PrevKeyHash)
+	if len(v.Nonce) > 0 {
+		t.Nonce = v.Nonce
+	}
+	t.RecoveryKey.E, t.RecoveryDelay = v.RecoveryE, v.RecoveryDelay
+	if len(v.RecoveryN) > 0 {
+		t.RecoveryKey.N = new(big.Int).SetBytes(v.RecoveryN)
+	}
+	return t
+}
+
+// compute the outputs from the inputs
+func (v *TxnVector) fill(chainID string) error {
+	if len(v.PrevKeyHash) != 0 && len(v.PrevKeyHash) != sha256.Size {
+		return fmt.Errorf("previous key hash must be %v bytes", sha256.Size)
+	}
+	t := v.transaction()
+	data, err := t.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	hash := t.Hash()
+	v.Encoding, v.Hash = data, hash[:]
+	v.SigningPayload, v.SigHash = nil, nil
+	if payload, err := txn.SigningPayload(&t, chainID); err == nil {
+		sigHash := sha256.Sum256(payload)
+		v.SigningPayload, v.SigHash = payload, sigHash[:]
+	}
+	return nil
+}
+
+func (v *BlockVector) block(txns map[string]txn.Transaction) (Block, error) {
+	b := Block{
+		BlockHeader: BlockHeader{
+			Version:    v.Version,
+			Timestamp:  v.Timestamp,
+			Difficulty: v.Difficulty,
+			Nonce:      v.Nonce,
+		},
+		SeqNum: v.SeqNum,
+	}
+	if len(v.ParentHash) != sha256.Size || len(v.StateRoot) != sha256.Size {
+		return b, fmt.Errorf("parent hash and state root must be %v bytes", sha256.Size)
+	}
+	copy(b.ParentHash[:], v.ParentHash)
+	copy(b.StateRoot[:], v.StateRoot)
+	for _, name := range v.Transactions {
+		t, ok := txns[name]
+		if !ok {
+			return b, fmt.Errorf("no transaction vector %q", name)
+		}
+		b.Transactions = append(b.Transactions, t)
+	}
+	b.MerkleRoot = MerkleRoot(b.Transactions)
+	b.Hash = b.GetHash()
+	return b, nil
+}
+
+func (v *BlockVector) fill(txns map[string]txn.Transaction) error {
+	b, err := v.block(txns)
+	if err != nil {
+		return err
+	}
+	data, err := b.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	v.MerkleRoot, v.Encoding, v.Hash = b.MerkleRoot[:], data, b.Hash[:]
+	return nil
+}
+
+// Check recomputes every vector from its inputs and compares, decodes every
+// encoding and checks it comes back the same, and checks that the invalid
+// encodings are refused
+func (vs *Vectors) Check() error {
+	if vs.EncodingVersion != txn.EncodingVersion {
+		return fmt.Errorf("vectors are for encoding version %v, we have %v", vs.EncodingVersion, txn.EncodingVersion)
+	}
+	txns := make(map[string]txn.Transaction)
+	for _, v := range vs.Transactions {
+		want := v
+		if err := want.fill(vs.ChainID); err != nil {
+			return fmt.Errorf("transaction %v: %v", v.Name, err)
+		}
+		if err := compareVector(v.Encoding, want.Encoding, v.Hash, want.Hash); err != nil {
+			return fmt.Errorf("transaction %v: %v", v.Name, err)
+		}
+		if !bytes.Equal(v.SigningPayload, want.SigningPayload) {
+			return fmt.Errorf("transaction %v: signing payload is %x, not %x", v.Name, want.SigningPayload, v.SigningPayload)
+		}
+		if !bytes.Equal(v.SigHash, want.SigHash) {
+			return fmt.Errorf("transaction %v: sig hash is %x, not %x", v.Name, want.SigHash, v.SigHash)
+		}
+		var t txn.Transaction
+		if err := t.UnmarshalBinary(v.Encoding); err != nil {
+			return fmt.Errorf("transaction %v doesn't decode: %v", v.Name, err)
+		}
+		if again, _ := t.MarshalBinary(); !bytes.Equal(again, v.Encoding) {
+			return fmt.Errorf("transaction %v doesn't decode to itself", v.Name)
+		}
+		txns[v.Name] = v.transaction()
+	}
+	for _, v := range vs.Blocks {
+		want := v
+		if err := want.fill(txns); err != nil {
+			return fmt.Errorf("block %v: %v", v.Name, err)
+		}
+		if err := compareVector(v.Encoding, want.Encoding, v.Hash, want.Hash); err != nil {
+			return fmt.Errorf("block %v: %v", v.Name, err)
+		}
+		if !bytes.Equal(v.MerkleRoot, want.MerkleRoot) {
+			return fmt.Errorf("block %v: Merkle root is %x, not %x", v.Name, want.MerkleRoot, v.MerkleRoot)
+		}
+		var b Block
+		if err := b.UnmarshalBinary(v.Encoding); err != nil {
+			return fmt.Errorf("block %v doesn't decode: %v", v.Name, err)
+		}
+		if again, _ := b.MarshalBinary(); !bytes.Equal(again, v.Encoding) || !bytes.Equal(b.Hash[:], v.Hash) {
+			return fmt.Errorf("block %v doesn't decode to itself", v.Name)
+		}
+	}
+	for _, v := range vs.Invalid {
+		var err error
+		switch v.Kind {
+		case "transaction":
+			err = new(txn.Transaction).UnmarshalBinary(v.Encoding)
+		case "block":
+			err = new(Block).UnmarshalBinary(v.Encoding)
+		default:
+			return fmt.Errorf("invalid %v: unknown kind %q", v.Name, v.Kind)
+		}
+		if err == nil {
+			return fmt.Errorf("invalid %v %v decodes", v.Kind, v.Name)
+		}
+	}
+	return nil
+}
+
+func compareVector(enc hexBytes, wantEnc hexBytes, hash hexBytes, wantHash hexBytes) error {
+	if !bytes.Equal(enc, wantEnc) {
+		return fmt.Errorf("encodes to %x, not %x", wantEnc, enc)
+	}
+	if !bytes.Equal(hash, wantHash) {
+		return fmt.Errorf("hash is %x, not %x", wantHash, hash)
+	}
+	return nil
+}
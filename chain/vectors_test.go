@@ -0,0 +1,178 @@
+package chain
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"testing"
+
+	"github.com/tslilyai/SLYkey/txn"
+)
+
+var update = flag.Bool("update", false, "rewrite testdata/vectors.json from makeVectors")
+
+var vectorsPath = filepath.Join("testdata", "vectors.json")
+
+// the golden vectors have to check out against this code, and be what
+// makeVectors makes
+func TestVectors(t *testing.T) {
+	vs, err := makeVectors()
+	if err != nil {
+		t.Fatal(err)
+	}
+	made, err := json.MarshalIndent(vs, "", "\t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	made = append(made, '\n')
+	if *update {
+		if err := ioutil.WriteFile(vectorsPath, made, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	data, err := ioutil.ReadFile(vectorsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var golden Vectors
+	if err := json.Unmarshal(data, &golden); err != nil {
+		t.Fatalf("%v: %v", vectorsPath, err)
+	}
+	if err := golden.Check(); err != nil {
+		t.Fatalf("%v: %v", vectorsPath, err)
+	}
+	if !bytes.Equal(data, made) {
+		t.Fatalf("%v is out of date; go test ./chain -run TestVectors -update rewrites it", vectorsPath)
+	}
+}
+
+// deterministic filler for keys, signatures and roots
+func vectorBytes(label string, n int) []byte {
+	var out []byte
+	for i := 0; len(out) < n; i++ {
+		sum := sha256.Sum256([]byte(fmt.Sprintf("slykey vectors %v %v", label, i)))
+		out = append(out, sum[:]...)
+	}
+	return out[:n]
+}
+
+// a made-up modulus of size bytes; it only has to look like one
+func vectorModulus(label string, size int) []byte {
+	n := vectorBytes(label, size)
+	n[0] |= 0x80
+	return n
+}
+
+// makeVectors builds the vectors from scratch, from fixed inputs
+func makeVectors() (*Vectors, error) {
+	alice := vectorModulus("alice key", 128)
+	aliceHash := txn.KeyHash(&rsa.PublicKey{N: new(big.Int).SetBytes(alice), E: 65537})
+	dave := vectorModulus("dave key", 128)
+	daveHash := txn.KeyHash(&rsa.PublicKey{N: new(big.Int).SetBytes(dave), E: 65537})
+	vs := &Vectors{
+		EncodingVersion: txn.EncodingVersion,
+		ChainID:         MainNetParams.ChainID,
+		Transactions: []TxnVector{
+			{Name: "register", Type: txn.Register, Email: "alice@example.com",
+				N: alice, E: 65537, Nonce: vectorBytes("alice nonce", txn.NonceSize),
+				Signature: vectorBytes("alice registration", 128)},
+			{Name: "update", Type: txn.Update, Email: "alice@example.com",
+				N: vectorModulus("alice new key", 128), E: 65537, Sequence: 1, PrevKeyHash: aliceHash[:],
+				Signature: vectorBytes("alice update", 128)},
+			{Name: "revoke", Type: txn.Revoke, Email: "carol@example.com", Sequence: 3,
+				PrevKeyHash: vectorBytes("carol key hash", sha256.Size), Signature: vectorBytes("carol revocation", 64)},
+			{Name: "register with recovery", Type: txn.Register, Email: "dave@example.com",
+				N: dave, E: 65537, Nonce: vectorBytes("dave nonce", txn.NonceSize),
+				RecoveryN: vectorModulus("dave recovery key", 128), RecoveryE: 65537, RecoveryDelay: 100,
+				Signature: vectorBytes("dave registration", 128)},
+			{Name: "recover", Type: txn.Recover, Email: "dave@example.com",
+				N: vectorModulus("dave new key", 128), E: 65537, Sequence: 1, PrevKeyHash: daveHash[:],
+				Signature: vectorBytes("dave recovery", 128)},
+			{Name: "cancel recovery", Type: txn.CancelRecovery, Email: "dave@example.com", Sequence: 2,
+				PrevKeyHash: daveHash[:], Signature: vectorBytes("dave cancellation", 128)},
+			{Name: "add key", Type: txn.AddKey, Email: "alice@example.com",
+				N: vectorModulus("alice laptop key", 128), E: 65537, Sequence: 2, PrevKeyHash: aliceHash[:],
+				Label: "laptop", Purpose: txn.PurposeSign | txn.PurposeManage, Signature: vectorBytes("alice key addition", 128)},
+			{Name: "remove key", Type: txn.RemoveKey, Email: "alice@example.com", Sequence: 3,
+				PrevKeyHash: aliceHash[:], Label: "laptop", Signature: vectorBytes("alice key removal", 128)},
+			{Name: "unsigned", Type: txn.Register, Email: "bob@example.com",
+				N: vectorModulus("bob key", 64), E: 3},
+			{Name: "empty"},
+		},
+	}
+	txns := make(map[string]txn.Transaction)
+	for i := range vs.Transactions {
+		v := &vs.Transactions[i]
+		if err := v.fill(vs.ChainID); err != nil {
+			return nil, fmt.Errorf("%v: %v", v.Name, err)
+		}
+		txns[v.Name] = v.transaction()
+	}
+
+	genesis := MainNetParams.Genesis()
+	vs.Blocks = []BlockVector{
+		{Name: "genesis", Version: genesis.Version, ParentHash: genesis.ParentHash[:],
+			StateRoot: genesis.StateRoot[:], Difficulty: genesis.Difficulty},
+		{Name: "one transaction", Version: BlockVersion, StateRoot: vectorBytes("state 1", sha256.Size),
+			Timestamp: 1448928000, Difficulty: 1000, Nonce: 7, SeqNum: 1,
+			Transactions: []string{"register"}},
+		{Name: "three transactions", Version: BlockVersion, StateRoot: vectorBytes("state 2", sha256.Size),
+			Timestamp: 1448928600, Difficulty: 1000, Nonce: 12345, SeqNum: 2,
+			Transactions: []string{"update", "unsigned", "empty"}},
+	}
+	for i := range vs.Blocks {
+		v := &vs.Blocks[i]
+		if i > 0 {
+			// chain them up
+			v.ParentHash = vs.Blocks[i-1].Hash
+		}
+		if err := v.fill(txns); err != nil {
+			return nil, fmt.Errorf("%v: %v", v.Name, err)
+		}
+	}
+
+	reg := vs.Transactions[0].Encoding
+	one := vs.Blocks[1].Encoding
+	modified := func(data []byte, fn func(b []byte) []byte) hexBytes {
+		return fn(append([]byte(nil), data...))
+	}
+	// bob's registration with a zero byte in front of the modulus
+	var bob TxnVector
+	for _, v := range vs.Transactions {
+		if v.Name == "unsigned" {
+			bob = v
+		}
+	}
+	padded := []byte{txn.EncodingVersion, byte(bob.Type)}
+	padded = binary.BigEndian.AppendUint64(padded, uint64(len(bob.Email)))
+	padded = append(padded, bob.Email...)
+	padded = binary.BigEndian.AppendUint64(padded, uint64(1+len(bob.N)))
+	padded = append(append(padded, 0), bob.N...)
+	padded = binary.BigEndian.AppendUint64(padded, uint64(bob.E))
+	padded = binary.BigEndian.AppendUint64(padded, 0)     // sequence
+	padded = append(padded, make([]byte, sha256.Size)...) // previous key hash
+	padded = binary.BigEndian.AppendUint64(padded, 0)     // nonce
+	padded = binary.BigEndian.AppendUint64(padded, 0)     // recovery key modulus
+	padded = binary.BigEndian.AppendUint64(padded, 0)     // recovery key exponent
+	padded = binary.BigEndian.AppendUint64(padded, 0)     // recovery delay
+	padded = binary.BigEndian.AppendUint64(padded, 0)     // label
+	padded = append(padded, 0)                            // purpose
+	padded = binary.BigEndian.AppendUint64(padded, 0)     // signature
+	vs.Invalid = []InvalidVector{
+		{"unknown version", "transaction", modified(reg, func(b []byte) []byte { b[0]++; return b })},
+		{"trailing byte", "transaction", modified(reg, func(b []byte) []byte { return append(b, 0) })},
+		{"truncated", "transaction", modified(reg, func(b []byte) []byte { return b[:len(b)-1] })},
+		{"padded modulus", "transaction", padded},
+		{"unknown version", "block", modified(one, func(b []byte) []byte { b[0]++; return b })},
+		{"trailing byte", "block", modified(one, func(b []byte) []byte { return append(b, 0) })},
+		{"missing transaction", "block", modified(one, func(b []byte) []byte { b[1+HeaderSize+8+7]++; return b })},
+	}
+	return vs, nil
+}
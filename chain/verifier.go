@@ -0,0 +1,41 @@
+package chain
+
+import (
+	"crypto/rsa"
+	"fmt"
+)
+
+// VerifyBlockChain verifies that the entire blockchain is valid and returns
+// the directory it builds. The chain follows params, registrations must be
+// signed by caKey, and no block may be too far ahead of now (unix seconds).
+func VerifyBlockChain(chain BlockStore, params *ChainParams, caKey *rsa.PublicKey, now int64) (*Directory, error) {
+	var err error
+	db := NewDirectory()
+	view := NewStoreView(chain, params)
+	// for each block in block chain, in SeqNum order
+	chain.ForEach(func(block Block) bool {
+		if block.SeqNum == 0 {
+			// the dummy block is taken as given
+			if block.Hash != params.Genesis().Hash {
+				err = fmt.Errorf("Verifier could not complete: wrong genesis block")
+				return false
+			}
+			return true
+		}
+		if e := block.ValidateHash(view, now); e != nil {
+			err = fmt.Errorf("Verifier could not complete: invalid block hash")
+			return false
+		}
+		if e := block.ValidateTxn(db, params, caKey); e != nil {
+			err = fmt.Errorf("Verifier could not complete: invalid block transactions")
+			return false
+		}
+		ApplyBlock(db, &block)
+		if db.Root() != block.StateRoot {
+			err = fmt.Errorf("Verifier could not complete: directory does not match block %v", block.SeqNum)
+			return false
+		}
+		return true
+	})
+	return db, err
+}
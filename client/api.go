@@ -0,0 +1,165 @@
+// Package client is for programs that talk to SLYkey nodes: the JSON
+// schema of a node's client API (request and response bodies, key
+// encodings and error codes) with a Go client for it, and a LightClient
+// that follows the chain by headers over the node RPCs.
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// API paths, relative to the node's API address
+const (
+	PathKeys         = "/v1/keys/"        // + email
+	PathTransactions = "/v1/transactions" // POST
+	PathBlocks       = "/v1/blocks/"      // + SeqNum
+	PathBlocksByHash = "/v1/blocks/hash/" // + hex block hash
+	PathStatus       = "/v1/status"
+)
+
+// transaction types on the wire
+const (
+	TxnRegister       = "register"
+	TxnUpdate         = "update"
+	TxnRevoke         = "revoke"
+	TxnRecover        = "recover"
+	TxnCancelRecovery = "cancel_recovery"
+	TxnAddKey         = "add_key"
+	TxnRemoveKey      = "remove_key"
+)
+
+// what a lookup found for an email
+const (
+	KeyActive   = "active"
+	KeyRevoked  = "revoked"
+	KeyNotFound = "not_found"
+)
+
+// error codes; a failed request answers with an ErrorResponse holding one
+const (
+	CodeBadRequest     = "bad_request"
+	CodeNotFound       = "not_found"
+	CodeMethod         = "method_not_allowed"
+	CodeInvalidTxn     = "invalid_transaction"
+	CodeDuplicateTxn   = "duplicate_transaction"
+	CodeConflictingTxn = "conflicting_transaction"
+	CodeMempoolFull    = "mempool_full"
+	CodeInternal       = "internal"
+)
+
+type Error struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%v: %v", e.Code, e.Message)
+}
+
+type ErrorResponse struct {
+	Error Error `json:"error"`
+}
+
+// Transaction is a signed transaction of any of the Txn* types: register,
+// update, revoke, recover, cancel_recovery, add_key or remove_key (see
+// txn.TransType for who signs each). Sequence is 0 for a registration,
+// which carries the CA's Nonce; anything else is one past the identity's
+// Sequence and names the identity's current key by PrevKeyHash (hex, see
+// txn.KeyHash). PublicKey is the new key of a registration, update or
+// recovery, or the added key of an add_key, and is empty for the rest.
+// Only a registration may have a RecoveryKey (with its RecoveryDelay in
+// blocks). Nonce and Signature are base64, as encoding/json does for
+// []byte.
+type Transaction struct {
+	Type          string `json:"type"`
+	Email         string `json:"email"`
+	PublicKey     Key    `json:"public_key"`
+	Sequence      uint64 `json:"sequence"`
+	PrevKeyHash   string `json:"prev_key_hash,omitempty"`
+	Nonce         []byte `json:"nonce,omitempty"`
+	RecoveryKey   *Key   `json:"recovery_key,omitempty"`
+	RecoveryDelay uint64 `json:"recovery_delay,omitempty"`
+	// add_key and remove_key only: which key of the identity's set, 1 to
+	// txn.MaxLabelLength bytes and never txn.PrimaryLabel
+	Label string `json:"label,omitempty"`
+	// add_key only: what the added key is for, like "sign,encrypt" (see
+	// txn.ParsePurpose)
+	Purpose   string `json:"purpose,omitempty"`
+	Signature []byte `json:"signature"`
+}
+
+// IdentityKey is one key of an identity's key set; the primary key is
+// labelled txn.PrimaryLabel and has every purpose
+type IdentityKey struct {
+	Label     string `json:"label"`
+	Purpose   string `json:"purpose"`
+	PublicKey Key    `json:"public_key"`
+}
+
+// SubmitResponse says a transaction was accepted into the node's mempool
+type SubmitResponse struct {
+	Hash string `json:"hash"` // hex
+}
+
+// KeyResponse is the answer to a lookup, as of the block at SeqNum. Status
+// is KeyActive, KeyRevoked or KeyNotFound; Found is true for the first two,
+// but only an active identity has a PublicKey. A revoked one says in which
+// block it was revoked. Sequence is the identity's, which the next update
+// has to be one past. RecoveryDelay is 0 unless the identity has a recovery
+// key; a pending recovery puts PendingKey in place at block RecoverAt,
+// unless the current key cancels it. Keys is the active identity's key set,
+// or the part of it that has every purpose in Purpose if the lookup asked
+// for one. Proof is the node's KeyProof for it, which checks out against
+// BlockHash and covers the whole set.
+type KeyResponse struct {
+	Email         string          `json:"email"`
+	Found         bool            `json:"found"`
+	Status        string          `json:"status"`
+	PublicKey     *Key            `json:"public_key,omitempty"`
+	RevokedAt     uint64          `json:"revoked_at,omitempty"`
+	Sequence      uint64          `json:"sequence"`
+	RecoveryDelay uint64          `json:"recovery_delay,omitempty"`
+	PendingKey    *Key            `json:"pending_key,omitempty"`
+	RecoverAt     uint64          `json:"recover_at,omitempty"`
+	Purpose       string          `json:"purpose,omitempty"`
+	Keys          []IdentityKey   `json:"keys,omitempty"`
+	SeqNum        uint64          `json:"seq_num"`
+	BlockHash     string          `json:"block_hash"`
+	Proof         json.RawMessage `json:"proof"`
+}
+
+// Header is a block header, hashes in hex
+type Header struct {
+	Version    uint32 `json:"version"`
+	ParentHash string `json:"parent_hash"`
+	MerkleRoot string `json:"merkle_root"`
+	StateRoot  string `json:"state_root"`
+	Timestamp  int64  `json:"timestamp"`
+	Difficulty uint64 `json:"difficulty"`
+	Nonce      uint64 `json:"nonce"`
+}
+
+type Block struct {
+	SeqNum       uint64        `json:"seq_num"`
+	Hash         string        `json:"hash"`
+	Header       Header        `json:"header"`
+	Transactions []Transaction `json:"transactions"`
+}
+
+type SyncStatus struct {
+	Syncing      bool   `json:"syncing"`
+	Peer         string `json:"peer,omitempty"`
+	TargetSeqNum uint64 `json:"target_seq_num,omitempty"`
+	LastError    string `json:"last_error,omitempty"`
+}
+
+type Status struct {
+	ChainID    string     `json:"chain_id"` // what update signatures commit to
+	TipSeqNum  uint64     `json:"tip_seq_num"`
+	TipHash    string     `json:"tip_hash"`
+	Difficulty uint64     `json:"difficulty"`
+	Peers      []string   `json:"peers"`
+	Mempool    int        `json:"mempool"`
+	Sync       SyncStatus `json:"sync"`
+}
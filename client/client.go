@@ -0,0 +1,125 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Client talks to one node's client API
+type Client struct {
+	BaseURL string // like http://127.0.0.1:8081
+	Format  string // key format to ask for; PEM if empty
+	HTTP    *http.Client
+}
+
+func New(baseURL string) *Client {
+	return &Client{
+		BaseURL: strings.TrimRight(baseURL, "/"),
+		HTTP:    &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// do sends the request and decodes the response into v, or the node's
+// error into an *Error
+func (c *Client) do(method string, path string, body interface{}, v interface{}) error {
+	var rd *bytes.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		rd = bytes.NewReader(data)
+	} else {
+		rd = bytes.NewReader(nil)
+	}
+	u := c.BaseURL + path
+	if c.Format != "" {
+		sep := "?"
+		if strings.Contains(path, "?") {
+			sep = "&"
+		}
+		u += sep + "format=" + url.QueryEscape(c.Format)
+	}
+	req, err := http.NewRequest(method, u, rd)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	res, err := c.HTTP.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 300 {
+		var e ErrorResponse
+		if err := json.NewDecoder(res.Body).Decode(&e); err != nil || e.Error.Code == "" {
+			return fmt.Errorf("%v %v: %v", method, path, res.Status)
+		}
+		return &e.Error
+	}
+	return json.NewDecoder(res.Body).Decode(v)
+}
+
+// Lookup asks for email's key; a KeyResponse with Found false is an answer,
+// not an error. See VerifyKey for checking it.
+func (c *Client) Lookup(email string) (*KeyResponse, error) {
+	var r KeyResponse
+	if err := c.do(http.MethodGet, PathKeys+url.PathEscape(email), nil, &r); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// LookupPurpose is Lookup with only the keys for purpose (see
+// txn.ParsePurpose) in the answer's Keys
+func (c *Client) LookupPurpose(email string, purpose string) (*KeyResponse, error) {
+	var r KeyResponse
+	path := PathKeys + url.PathEscape(email) + "?purpose=" + url.QueryEscape(purpose)
+	if err := c.do(http.MethodGet, path, nil, &r); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// Submit hands a signed transaction to the node for mining
+func (c *Client) Submit(t *Transaction) (*SubmitResponse, error) {
+	var r SubmitResponse
+	if err := c.do(http.MethodPost, PathTransactions, t, &r); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// Block fetches block seqNum of the node's main chain
+func (c *Client) Block(seqNum uint64) (*Block, error) {
+	var b Block
+	if err := c.do(http.MethodGet, PathBlocks+strconv.FormatUint(seqNum, 10), nil, &b); err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+// BlockByHash fetches a block by its hex hash, from any branch the node knows
+func (c *Client) BlockByHash(hash string) (*Block, error) {
+	var b Block
+	if err := c.do(http.MethodGet, PathBlocksByHash+hash, nil, &b); err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+func (c *Client) Status() (*Status, error) {
+	var s Status
+	if err := c.do(http.MethodGet, PathStatus, nil, &s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
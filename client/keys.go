@@ -0,0 +1,91 @@
+package client
+
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+)
+
+// key formats
+const (
+	FormatPEM = "pem"
+	FormatJWK = "jwk"
+)
+
+// Key is an RSA public key in one of two encodings; responses use whichever
+// the request asked for (?format=pem or ?format=jwk, PEM by default), and
+// requests may use either.
+type Key struct {
+	PEM string `json:"pem,omitempty"` // PKIX "PUBLIC KEY" block
+	JWK *JWK   `json:"jwk,omitempty"`
+}
+
+// JWK is an RSA public key as a JSON Web Key (RFC 7517/7518)
+type JWK struct {
+	Kty string `json:"kty"` // always "RSA"
+	N   string `json:"n"`   // base64url modulus
+	E   string `json:"e"`   // base64url exponent
+}
+
+// NewKey encodes key in format
+func NewKey(key *rsa.PublicKey, format string) (*Key, error) {
+	if key.N == nil {
+		return nil, fmt.Errorf("no key to encode")
+	}
+	switch format {
+	case FormatPEM, "":
+		der, err := x509.MarshalPKIXPublicKey(key)
+		if err != nil {
+			return nil, err
+		}
+		return &Key{PEM: string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))}, nil
+	case FormatJWK:
+		return &Key{JWK: &JWK{
+			Kty: "RSA",
+			N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}, nil
+	}
+	return nil, fmt.Errorf("unknown key format %q", format)
+}
+
+// PublicKey decodes k, whichever encoding it is in
+func (k *Key) PublicKey() (*rsa.PublicKey, error) {
+	if k.PEM != "" {
+		block, _ := pem.Decode([]byte(k.PEM))
+		if block == nil || block.Type != "PUBLIC KEY" {
+			return nil, fmt.Errorf("not a PEM encoded public key")
+		}
+		pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		key, ok := pub.(*rsa.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("not an RSA key")
+		}
+		return key, nil
+	}
+	if k.JWK != nil {
+		if k.JWK.Kty != "RSA" {
+			return nil, fmt.Errorf("not an RSA key")
+		}
+		n, err := base64.RawURLEncoding.DecodeString(k.JWK.N)
+		if err != nil {
+			return nil, fmt.Errorf("bad modulus: %v", err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.JWK.E)
+		if err != nil {
+			return nil, fmt.Errorf("bad exponent: %v", err)
+		}
+		exp := new(big.Int).SetBytes(e)
+		if !exp.IsInt64() || exp.Int64() > 1<<31-1 || exp.Int64() < 3 {
+			return nil, fmt.Errorf("bad exponent")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exp.Int64())}, nil
+	}
+	return nil, fmt.Errorf("no key")
+}
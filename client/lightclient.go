@@ -0,0 +1,248 @@
+package client
+
+import (
+	"crypto/rsa"
+	"crypto/sha256"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/tslilyai/SLYkey/chain"
+	"github.com/tslilyai/SLYkey/rpc"
+	"github.com/tslilyai/SLYkey/txn"
+)
+
+const (
+	// headers asked for per round trip while syncing
+	HeaderBatch = 500
+	// a lookup proof must be against one of this many blocks at our tip;
+	// anything older could be hiding a later update
+	MaxProofLag = 6
+)
+
+type headerNode struct {
+	header chain.BlockHeader
+	hash   [sha256.Size]byte
+	seqNum uint64
+	parent *headerNode
+	work   *big.Int
+}
+
+// as a Block, so the retarget and median time rules apply to it as is
+func (n *headerNode) asBlock() chain.Block {
+	return chain.Block{BlockHeader: n.header, SeqNum: n.seqNum, Hash: n.hash}
+}
+
+func (n *headerNode) ancestor(seqNum uint64) chain.Block {
+	for n.seqNum > seqNum && n.parent != nil {
+		n = n.parent
+	}
+	return n.asBlock()
+}
+
+// LightClient follows the chain by headers alone: it checks proof of work,
+// difficulty and timestamps on every header, picks the branch with the most
+// work, and believes a key lookup from a full node only if it is proven
+// against a header near the tip of that branch.
+type LightClient struct {
+	mu      sync.Mutex
+	peers   []string
+	pool    *rpc.ConnPool
+	headers map[[sha256.Size]byte]*headerNode
+	tip     *headerNode
+	params  *chain.ChainParams
+}
+
+// LookupResult is what a verified lookup says about Email
+type LookupResult struct {
+	Email     string
+	Found     bool
+	PublicKey rsa.PublicKey
+	Sequence  uint64 // of the identity
+	// a revoked identity's PublicKey is the one it had, not to be used;
+	// RevokedAt is the SeqNum of the block that revoked it
+	Revoked   bool
+	RevokedAt uint64
+	// a pending recovery puts PendingKey in place from block RecoverAt on
+	// (0 if there is none)
+	PendingKey rsa.PublicKey
+	RecoverAt  uint64
+	// the whole key set, PublicKey first; see txn.WithPurpose for picking
+	// from it. Empty unless the identity is found and not revoked, so
+	// nobody picks a key to use from one that is gone.
+	Keys []txn.Key
+	// the header (and its block's SeqNum) the answer was proven against
+	Header chain.BlockHeader
+	SeqNum uint64
+}
+
+// t says how to reach peers; unix domain sockets if nil. params are the
+// rules of the network; MainNetParams if nil.
+func NewLightClient(peers []string, t rpc.Transport, params *chain.ChainParams) *LightClient {
+	if t == nil {
+		t = rpc.UnixTransport{}
+	}
+	if params == nil {
+		p := chain.MainNetParams
+		params = &p
+	}
+	g := params.Genesis()
+	root := &headerNode{header: g.BlockHeader, hash: g.Hash, work: new(big.Int)}
+	return &LightClient{
+		peers:   peers,
+		pool:    rpc.NewConnPool(t, nil),
+		headers: map[[sha256.Size]byte]*headerNode{g.Hash: root},
+		tip:     root,
+		params:  params,
+	}
+}
+
+// Tip returns the header of the best block we know of and its SeqNum
+func (lc *LightClient) Tip() (chain.BlockHeader, uint64) {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	return lc.tip.header, lc.tip.seqNum
+}
+
+// Precondition: mu acquired
+// add a header that claims to be block seqNum, if it checks out
+func (lc *LightClient) addHeader(h chain.BlockHeader, seqNum uint64) (*headerNode, error) {
+	hash := h.Hash()
+	if n, ok := lc.headers[hash]; ok {
+		return n, nil
+	}
+	parent, ok := lc.headers[h.ParentHash]
+	if !ok {
+		return nil, fmt.Errorf("unknown parent")
+	}
+	if seqNum != parent.seqNum+1 {
+		return nil, fmt.Errorf("header %v: wrong position", seqNum)
+	}
+	if h.Version != chain.BlockVersion {
+		return nil, fmt.Errorf("header %v: unknown version %v", seqNum, h.Version)
+	}
+	if h.Difficulty != lc.params.NextDifficulty(parent.asBlock(), parent.ancestor) {
+		return nil, fmt.Errorf("header %v: wrong difficulty", seqNum)
+	}
+	if !chain.HashMeetsDifficulty(hash, h.Difficulty) {
+		return nil, fmt.Errorf("header %v: bad proof of work", seqNum)
+	}
+	b := chain.Block{BlockHeader: h, SeqNum: seqNum, Hash: hash}
+	if !chain.CheckTimestamp(b, chain.MedianTimePast(parent.asBlock(), parent.ancestor), time.Now().Unix()) {
+		return nil, fmt.Errorf("header %v: bad timestamp", seqNum)
+	}
+	n := &headerNode{
+		header: h,
+		hash:   hash,
+		seqNum: seqNum,
+		parent: parent,
+		work:   new(big.Int).Add(parent.work, new(big.Int).SetUint64(h.Difficulty)),
+	}
+	lc.headers[hash] = n
+	if n.work.Cmp(lc.tip.work) > 0 {
+		lc.tip = n
+	}
+	return n, nil
+}
+
+// Sync pulls headers from every peer until none of them has more
+func (lc *LightClient) Sync() {
+	for _, peer := range lc.peers {
+		lc.syncFrom(peer)
+	}
+}
+
+func (lc *LightClient) syncFrom(peer string) {
+	lc.mu.Lock()
+	from := lc.tip.seqNum + 1
+	lc.mu.Unlock()
+
+	for {
+		args := rpc.RequestHeadersArgs{From: from, Count: HeaderBatch}
+		reply := rpc.RequestHeadersReply{}
+		if !lc.pool.Call(peer, "ns.RemoteHeaderLookup", args, &reply) || reply.Status != rpc.ErrFound {
+			return
+		}
+		lc.mu.Lock()
+		if _, ok := lc.headers[reply.Headers[0].ParentHash]; !ok {
+			// peer is on another branch; back up until we find where it forks
+			lc.mu.Unlock()
+			if from == 1 {
+				return
+			}
+			if from > HeaderBatch {
+				from -= HeaderBatch
+			} else {
+				from = 1
+			}
+			continue
+		}
+		for i, h := range reply.Headers {
+			if _, err := lc.addHeader(h, from+uint64(i)); err != nil {
+				// the rest build on a bad header; don't trust this peer further
+				lc.mu.Unlock()
+				return
+			}
+		}
+		lc.mu.Unlock()
+		if uint64(len(reply.Headers)) < HeaderBatch {
+			return
+		}
+		from += uint64(len(reply.Headers))
+	}
+}
+
+// Lookup asks full nodes for email's key and returns the first answer whose
+// proof checks out against a header at the tip of our best chain
+func (lc *LightClient) Lookup(email string) (LookupResult, error) {
+	lc.Sync()
+	for _, peer := range lc.peers {
+		args := rpc.RequestKeyProofArgs{Email: email}
+		reply := rpc.RequestKeyProofReply{}
+		if !lc.pool.Call(peer, "ns.RemoteKeyLookup", args, &reply) || reply.Status != rpc.ErrFound {
+			continue
+		}
+		if res, err := lc.verify(email, &reply.Proof); err == nil {
+			return res, nil
+		}
+	}
+	return LookupResult{}, fmt.Errorf("no peer could prove a key for %v", email)
+}
+
+func (lc *LightClient) verify(email string, kp *chain.KeyProof) (LookupResult, error) {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+
+	if kp.Lookup.Email != email {
+		return LookupResult{}, fmt.Errorf("proof is for %v", kp.Lookup.Email)
+	}
+	n, ok := lc.headers[kp.Header.Hash()]
+	if !ok || n.seqNum != kp.SeqNum {
+		return LookupResult{}, fmt.Errorf("proof is against a header we don't know")
+	}
+	if lc.tip.seqNum > n.seqNum+MaxProofLag || lc.tip.ancestor(n.seqNum).Hash != n.hash {
+		return LookupResult{}, fmt.Errorf("proof is against a stale or losing block")
+	}
+	if err := kp.Verify(n.hash); err != nil {
+		return LookupResult{}, err
+	}
+	id := txn.Settle(&kp.Lookup.Identity, kp.SeqNum)
+	var keys []txn.Key
+	if kp.Lookup.Found && !id.Revoked {
+		keys = id.KeySet()
+	}
+	return LookupResult{
+		Email:      email,
+		Found:      kp.Lookup.Found,
+		PublicKey:  id.PublicKey,
+		Sequence:   id.Sequence,
+		Revoked:    id.Revoked,
+		RevokedAt:  id.RevokedAt,
+		PendingKey: id.PendingKey,
+		RecoverAt:  id.RecoverAt,
+		Keys:       keys,
+		Header:     n.header,
+		SeqNum:     n.seqNum,
+	}, nil
+}
@@ -0,0 +1,154 @@
+package client
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/tslilyai/SLYkey/txn"
+)
+
+// SignUpdate signs update number seq (one past the identity's current
+// Sequence, see KeyResponse) of email's key to newKey with the current key
+// for the network chainID (a node's Status says which), as UpdatePublicKey
+// checks it: see txn.SigningPayload
+func SignUpdate(current *rsa.PrivateKey, email string, newKey *rsa.PublicKey, seq uint64, chainID string) ([]byte, error) {
+	t := txn.Transaction{
+		Type:        txn.Update,
+		Email:       email,
+		PublicKey:   *newKey,
+		Sequence:    seq,
+		PrevKeyHash: txn.KeyHash(&current.PublicKey),
+	}
+	if err := txn.Sign(&t, current, chainID); err != nil {
+		return nil, err
+	}
+	return t.Signature, nil
+}
+
+// SignRevoke signs the revocation of email's key current as its
+// transaction number seq (see SignUpdate) with signer, which is either that
+// key or the identity's recovery key, for the network chainID, as
+// RevokePublicKey checks it
+func SignRevoke(signer *rsa.PrivateKey, email string, current *rsa.PublicKey, seq uint64, chainID string) ([]byte, error) {
+	t := txn.Transaction{
+		Type:        txn.Revoke,
+		Email:       email,
+		Sequence:    seq,
+		PrevKeyHash: txn.KeyHash(current),
+	}
+	if err := txn.Sign(&t, signer, chainID); err != nil {
+		return nil, err
+	}
+	return t.Signature, nil
+}
+
+// SignRecover signs, with email's recovery key, a recovery from its key
+// current to newKey as its transaction number seq, for the network chainID,
+// as RecoverPublicKey checks it
+func SignRecover(recovery *rsa.PrivateKey, email string, current *rsa.PublicKey, newKey *rsa.PublicKey, seq uint64, chainID string) ([]byte, error) {
+	t := txn.Transaction{
+		Type:        txn.Recover,
+		Email:       email,
+		PublicKey:   *newKey,
+		Sequence:    seq,
+		PrevKeyHash: txn.KeyHash(current),
+	}
+	if err := txn.Sign(&t, recovery, chainID); err != nil {
+		return nil, err
+	}
+	return t.Signature, nil
+}
+
+// SignCancelRecovery signs, with email's current key, the cancellation of
+// its pending recovery as its transaction number seq, for the network
+// chainID, as CancelRecovery checks it
+func SignCancelRecovery(current *rsa.PrivateKey, email string, seq uint64, chainID string) ([]byte, error) {
+	t := txn.Transaction{
+		Type:        txn.CancelRecovery,
+		Email:       email,
+		Sequence:    seq,
+		PrevKeyHash: txn.KeyHash(&current.PublicKey),
+	}
+	if err := txn.Sign(&t, current, chainID); err != nil {
+		return nil, err
+	}
+	return t.Signature, nil
+}
+
+// SignAddKey signs, with signer (email's primary key current or a key in
+// its set with txn.PurposeManage), the addition of key to email's key set
+// as label for purpose, as its transaction number seq, for the network
+// chainID, as AddKey checks it
+func SignAddKey(signer *rsa.PrivateKey, email string, current *rsa.PublicKey, key *rsa.PublicKey, label string, purpose txn.Purpose, seq uint64, chainID string) ([]byte, error) {
+	t := txn.Transaction{
+		Type:        txn.AddKey,
+		Email:       email,
+		PublicKey:   *key,
+		Sequence:    seq,
+		PrevKeyHash: txn.KeyHash(current),
+		Label:       label,
+		Purpose:     purpose,
+	}
+	if err := txn.Sign(&t, signer, chainID); err != nil {
+		return nil, err
+	}
+	return t.Signature, nil
+}
+
+// SignRemoveKey signs the removal of the key labelled label from email's
+// key set, like SignAddKey, as RemoveKey checks it
+func SignRemoveKey(signer *rsa.PrivateKey, email string, current *rsa.PublicKey, label string, seq uint64, chainID string) ([]byte, error) {
+	t := txn.Transaction{
+		Type:        txn.RemoveKey,
+		Email:       email,
+		Sequence:    seq,
+		PrevKeyHash: txn.KeyHash(current),
+		Label:       label,
+	}
+	if err := txn.Sign(&t, signer, chainID); err != nil {
+		return nil, err
+	}
+	return t.Signature, nil
+}
+
+// Registration is the CA's answer to a registration request: the nonce it
+// bound the registration to and its signature over it
+type Registration struct {
+	Nonce     []byte `json:"nonce"`
+	Signature []byte `json:"signature"`
+}
+
+// RequestRegistration asks the CA at caURL to sign email's registration of
+// key, like RegisterPublicKey does. If recovery isn't nil the registration
+// commits to it as the recovery key, with a delay of delay blocks.
+func RequestRegistration(caURL string, email string, key *rsa.PublicKey, recovery *rsa.PublicKey, delay uint64) (*Registration, error) {
+	t := txn.Transaction{Type: txn.Register, Email: email, PublicKey: *key}
+	if recovery != nil {
+		t.RecoveryKey, t.RecoveryDelay = *recovery, delay
+	}
+	data, err := json.Marshal(&t)
+	if err != nil {
+		return nil, err
+	}
+	res, err := http.Post(caURL, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("CA refused: %v: %s", res.Status, bytes.TrimSpace(body))
+	}
+	var reg Registration
+	if err := json.Unmarshal(body, &reg); err != nil {
+		return nil, fmt.Errorf("bad answer from the CA: %v", err)
+	}
+	return &reg, nil
+}
@@ -0,0 +1,100 @@
+package client
+
+import (
+	"crypto/rsa"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	"github.com/tslilyai/SLYkey/chain"
+	"github.com/tslilyai/SLYkey/txn"
+)
+
+// VerifyKey checks that r's proof shows its answer (key or no key) under
+// the directory root of the block r.BlockHash. That block being on the
+// chain with the most work is for the caller to decide, e.g. by asking
+// other nodes, or with a LightClient.
+func VerifyKey(r *KeyResponse) error {
+	var kp chain.KeyProof
+	if err := json.Unmarshal(r.Proof, &kp); err != nil {
+		return fmt.Errorf("bad proof: %v", err)
+	}
+	hash := kp.Header.Hash()
+	if hex.EncodeToString(hash[:]) != r.BlockHash || kp.SeqNum != r.SeqNum {
+		return fmt.Errorf("proof is against a different block")
+	}
+	// the answer is about the identity as of that block
+	proven := txn.Settle(&kp.Lookup.Identity, kp.SeqNum)
+	if kp.Lookup.Email != r.Email || kp.Lookup.Found != r.Found || proven.Sequence != r.Sequence {
+		return fmt.Errorf("proof is for a different answer")
+	}
+	if r.Status != KeyStatus(kp.Lookup.Found, proven.Revoked) || r.RevokedAt != proven.RevokedAt {
+		return fmt.Errorf("proof is for a different status")
+	}
+	if r.Status == KeyActive {
+		if err := sameKey(r.PublicKey, &proven.PublicKey); err != nil {
+			return err
+		}
+		if err := sameKeys(r.Keys, r.Purpose, proven); err != nil {
+			return err
+		}
+	} else if len(r.Keys) > 0 {
+		return fmt.Errorf("keys in the answer for a %v identity", r.Status)
+	}
+	if r.RecoveryDelay != proven.RecoveryDelay || r.RecoverAt != proven.RecoverAt {
+		return fmt.Errorf("proof is for a different recovery")
+	}
+	if r.RecoverAt != 0 {
+		if err := sameKey(r.PendingKey, &proven.PendingKey); err != nil {
+			return err
+		}
+	}
+	return kp.Verify(hash)
+}
+
+func sameKey(k *Key, proven *rsa.PublicKey) error {
+	if k == nil {
+		return fmt.Errorf("no key in the answer")
+	}
+	key, err := k.PublicKey()
+	if err != nil {
+		return err
+	}
+	if key.E != proven.E || proven.N == nil || key.N.Cmp(proven.N) != 0 {
+		return fmt.Errorf("proof is for a different key")
+	}
+	return nil
+}
+
+// keys has to be exactly the part of proven's key set for purpose, in order,
+// so a node can't leave any out
+func sameKeys(keys []IdentityKey, purpose string, proven *txn.Identity) error {
+	p, err := txn.ParsePurpose(purpose)
+	if err != nil {
+		return err
+	}
+	want := txn.WithPurpose(proven.KeySet(), p)
+	if len(keys) != len(want) {
+		return fmt.Errorf("proof has %v keys for %q, the answer %v", len(want), purpose, len(keys))
+	}
+	for i := range want {
+		if keys[i].Label != want[i].Label || keys[i].Purpose != want[i].Purpose.String() {
+			return fmt.Errorf("proof is for a different key set")
+		}
+		if err := sameKey(&keys[i].PublicKey, &want[i].PublicKey); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// KeyStatus is the KeyResponse.Status of a lookup
+func KeyStatus(found bool, revoked bool) string {
+	switch {
+	case !found:
+		return KeyNotFound
+	case revoked:
+		return KeyRevoked
+	}
+	return KeyActive
+}
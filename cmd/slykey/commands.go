@@ -0,0 +1,682 @@
+package main
+
+import (
+	"crypto/rsa"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+
+	"github.com/tslilyai/SLYkey/chain"
+	"github.com/tslilyai/SLYkey/client"
+	"github.com/tslilyai/SLYkey/txn"
+)
+
+type env struct {
+	api    *client.Client
+	ksDir  string
+	format string
+	json   bool
+}
+
+func newEnv(node string, ksDir string, format string, asJSON bool) *env {
+	api := client.New(node)
+	api.Format = format
+	return &env{api: api, ksDir: ksDir, format: format, json: asJSON}
+}
+
+func (e *env) keystore() (*Keystore, error) {
+	return OpenKeystore(e.ksDir)
+}
+
+func (e *env) flags(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: slykey %v %v\n", name, commands[name].usage)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// the one positional argument a command takes
+func oneArg(fs *flag.FlagSet, what string) (string, error) {
+	if fs.NArg() != 1 {
+		return "", fmt.Errorf("want exactly one %v", what)
+	}
+	return fs.Arg(0), nil
+}
+
+func (e *env) encodeKey(key *rsa.PublicKey) (*client.Key, error) {
+	return client.NewKey(key, e.format)
+}
+
+func printKey(k *client.Key) {
+	if k.PEM != "" {
+		fmt.Print(k.PEM)
+	} else if k.JWK != nil {
+		fmt.Printf("kty=%v n=%v e=%v\n", k.JWK.Kty, k.JWK.N, k.JWK.E)
+	}
+}
+
+func cmdKeygen(e *env, args []string) error {
+	fs := e.flags("keygen")
+	bits := fs.Int("bits", 2048, "RSA modulus size")
+	fs.Parse(args)
+	name, err := oneArg(fs, "key name")
+	if err != nil {
+		return err
+	}
+	ks, err := e.keystore()
+	if err != nil {
+		return err
+	}
+	priv, err := ks.Generate(name, *bits)
+	if err != nil {
+		return err
+	}
+	pub, err := e.encodeKey(&priv.PublicKey)
+	if err != nil {
+		return err
+	}
+	e.print(struct {
+		Name      string      `json:"name"`
+		PublicKey *client.Key `json:"public_key"`
+	}{name, pub}, func() {
+		fmt.Printf("generated %v\n", name)
+		printKey(pub)
+	})
+	return nil
+}
+
+func cmdKeys(e *env, args []string) error {
+	fs := e.flags("keys")
+	fs.Parse(args)
+	ks, err := e.keystore()
+	if err != nil {
+		return err
+	}
+	names, err := ks.Names()
+	if err != nil {
+		return err
+	}
+	if names == nil {
+		names = []string{}
+	}
+	e.print(names, func() {
+		for _, name := range names {
+			fmt.Println(name)
+		}
+	})
+	return nil
+}
+
+// submit t and report what the node said
+func (e *env) submit(t *client.Transaction) error {
+	res, err := e.api.Submit(t)
+	if err != nil {
+		return err
+	}
+	e.print(res, func() {
+		fmt.Printf("submitted %v transaction for %v: %v\n", t.Type, t.Email, res.Hash)
+	})
+	return nil
+}
+
+func cmdRegister(e *env, args []string) error {
+	fs := e.flags("register")
+	keyName := fs.String("key", "", "keystore key to register")
+	caURL := fs.String("ca", envOr("SLYKEY_CA", "https://ca.com/register"), "CA registration URL (or $SLYKEY_CA)")
+	recoveryName := fs.String("recovery", "", "keystore key that can recover email if -key is lost (optional)")
+	delay := fs.Uint64("delay", 100, "blocks a recovery waits for -key to cancel it")
+	fs.Parse(args)
+	email, err := oneArg(fs, "email")
+	if err != nil {
+		return err
+	}
+	if *keyName == "" {
+		return fmt.Errorf("-key is required")
+	}
+	ks, err := e.keystore()
+	if err != nil {
+		return err
+	}
+	priv, err := ks.Load(*keyName)
+	if err != nil {
+		return err
+	}
+	t := &client.Transaction{Type: client.TxnRegister, Email: email}
+	var recovery *rsa.PublicKey
+	if *recoveryName != "" {
+		rpriv, err := ks.Load(*recoveryName)
+		if err != nil {
+			return err
+		}
+		recovery = &rpriv.PublicKey
+		if t.RecoveryKey, err = e.encodeKey(recovery); err != nil {
+			return err
+		}
+		t.RecoveryDelay = *delay
+	}
+	reg, err := client.RequestRegistration(*caURL, email, &priv.PublicKey, recovery, *delay)
+	if err != nil {
+		return err
+	}
+	pub, err := e.encodeKey(&priv.PublicKey)
+	if err != nil {
+		return err
+	}
+	t.PublicKey, t.Nonce, t.Signature = *pub, reg.Nonce, reg.Signature
+	return e.submit(t)
+}
+
+func cmdUpdate(e *env, args []string) error {
+	fs := e.flags("update")
+	oldName := fs.String("old", "", "keystore key email has now")
+	newName := fs.String("new", "", "keystore key to move email to")
+	fs.Parse(args)
+	email, err := oneArg(fs, "email")
+	if err != nil {
+		return err
+	}
+	if *oldName == "" || *newName == "" {
+		return fmt.Errorf("-old and -new are required")
+	}
+	ks, err := e.keystore()
+	if err != nil {
+		return err
+	}
+	oldKey, err := ks.Load(*oldName)
+	if err != nil {
+		return err
+	}
+	newKey, err := ks.Load(*newName)
+	if err != nil {
+		return err
+	}
+	chainID, seq, _, err := e.next(email)
+	if err != nil {
+		return err
+	}
+	sig, err := client.SignUpdate(oldKey, email, &newKey.PublicKey, seq, chainID)
+	if err != nil {
+		return err
+	}
+	pub, err := e.encodeKey(&newKey.PublicKey)
+	if err != nil {
+		return err
+	}
+	return e.submit(&client.Transaction{
+		Type:        client.TxnUpdate,
+		Email:       email,
+		PublicKey:   *pub,
+		Sequence:    seq,
+		PrevKeyHash: keyHash(&oldKey.PublicKey),
+		Signature:   sig,
+	})
+}
+
+func cmdRevoke(e *env, args []string) error {
+	fs := e.flags("revoke")
+	keyName := fs.String("key", "", "keystore key email has now, or its recovery key")
+	fs.Parse(args)
+	email, err := oneArg(fs, "email")
+	if err != nil {
+		return err
+	}
+	if *keyName == "" {
+		return fmt.Errorf("-key is required")
+	}
+	ks, err := e.keystore()
+	if err != nil {
+		return err
+	}
+	priv, err := ks.Load(*keyName)
+	if err != nil {
+		return err
+	}
+	chainID, seq, current, err := e.next(email)
+	if err != nil {
+		return err
+	}
+	sig, err := client.SignRevoke(priv, email, current, seq, chainID)
+	if err != nil {
+		return err
+	}
+	return e.submit(&client.Transaction{
+		Type:        client.TxnRevoke,
+		Email:       email,
+		Sequence:    seq,
+		PrevKeyHash: keyHash(current),
+		Signature:   sig,
+	})
+}
+
+func cmdRecover(e *env, args []string) error {
+	fs := e.flags("recover")
+	recoveryName := fs.String("recovery", "", "keystore key email registered for recovery")
+	newName := fs.String("new", "", "keystore key to move email to")
+	fs.Parse(args)
+	email, err := oneArg(fs, "email")
+	if err != nil {
+		return err
+	}
+	if *recoveryName == "" || *newName == "" {
+		return fmt.Errorf("-recovery and -new are required")
+	}
+	ks, err := e.keystore()
+	if err != nil {
+		return err
+	}
+	recovery, err := ks.Load(*recoveryName)
+	if err != nil {
+		return err
+	}
+	newKey, err := ks.Load(*newName)
+	if err != nil {
+		return err
+	}
+	chainID, seq, current, err := e.next(email)
+	if err != nil {
+		return err
+	}
+	sig, err := client.SignRecover(recovery, email, current, &newKey.PublicKey, seq, chainID)
+	if err != nil {
+		return err
+	}
+	pub, err := e.encodeKey(&newKey.PublicKey)
+	if err != nil {
+		return err
+	}
+	return e.submit(&client.Transaction{
+		Type:        client.TxnRecover,
+		Email:       email,
+		PublicKey:   *pub,
+		Sequence:    seq,
+		PrevKeyHash: keyHash(current),
+		Signature:   sig,
+	})
+}
+
+func cmdCancelRecovery(e *env, args []string) error {
+	fs := e.flags("cancel-recovery")
+	keyName := fs.String("key", "", "keystore key email has now")
+	fs.Parse(args)
+	email, err := oneArg(fs, "email")
+	if err != nil {
+		return err
+	}
+	if *keyName == "" {
+		return fmt.Errorf("-key is required")
+	}
+	ks, err := e.keystore()
+	if err != nil {
+		return err
+	}
+	priv, err := ks.Load(*keyName)
+	if err != nil {
+		return err
+	}
+	chainID, seq, current, err := e.next(email)
+	if err != nil {
+		return err
+	}
+	sig, err := client.SignCancelRecovery(priv, email, seq, chainID)
+	if err != nil {
+		return err
+	}
+	return e.submit(&client.Transaction{
+		Type:        client.TxnCancelRecovery,
+		Email:       email,
+		Sequence:    seq,
+		PrevKeyHash: keyHash(current),
+		Signature:   sig,
+	})
+}
+
+func cmdAddKey(e *env, args []string) error {
+	fs := e.flags("add-key")
+	signerName := fs.String("signer", "", "keystore key email has now, or one of its keys with the manage purpose")
+	keyName := fs.String("key", "", "keystore key to add")
+	label := fs.String("label", "", "what to call the key, like a device name")
+	purpose := fs.String("purpose", "sign", "what the key is for: sign, encrypt and/or manage, comma separated")
+	fs.Parse(args)
+	email, err := oneArg(fs, "email")
+	if err != nil {
+		return err
+	}
+	if *signerName == "" || *keyName == "" || *label == "" {
+		return fmt.Errorf("-signer, -key and -label are required")
+	}
+	p, err := txn.ParsePurpose(*purpose)
+	if err != nil {
+		return err
+	}
+	ks, err := e.keystore()
+	if err != nil {
+		return err
+	}
+	signer, err := ks.Load(*signerName)
+	if err != nil {
+		return err
+	}
+	key, err := ks.Load(*keyName)
+	if err != nil {
+		return err
+	}
+	chainID, seq, current, err := e.next(email)
+	if err != nil {
+		return err
+	}
+	sig, err := client.SignAddKey(signer, email, current, &key.PublicKey, *label, p, seq, chainID)
+	if err != nil {
+		return err
+	}
+	pub, err := e.encodeKey(&key.PublicKey)
+	if err != nil {
+		return err
+	}
+	return e.submit(&client.Transaction{
+		Type:        client.TxnAddKey,
+		Email:       email,
+		PublicKey:   *pub,
+		Sequence:    seq,
+		PrevKeyHash: keyHash(current),
+		Label:       *label,
+		Purpose:     p.String(),
+		Signature:   sig,
+	})
+}
+
+func cmdRemoveKey(e *env, args []string) error {
+	fs := e.flags("remove-key")
+	signerName := fs.String("signer", "", "keystore key email has now, or one of its keys with the manage purpose")
+	label := fs.String("label", "", "label of the key to remove")
+	fs.Parse(args)
+	email, err := oneArg(fs, "email")
+	if err != nil {
+		return err
+	}
+	if *signerName == "" || *label == "" {
+		return fmt.Errorf("-signer and -label are required")
+	}
+	ks, err := e.keystore()
+	if err != nil {
+		return err
+	}
+	signer, err := ks.Load(*signerName)
+	if err != nil {
+		return err
+	}
+	chainID, seq, current, err := e.next(email)
+	if err != nil {
+		return err
+	}
+	sig, err := client.SignRemoveKey(signer, email, current, *label, seq, chainID)
+	if err != nil {
+		return err
+	}
+	return e.submit(&client.Transaction{
+		Type:        client.TxnRemoveKey,
+		Email:       email,
+		Sequence:    seq,
+		PrevKeyHash: keyHash(current),
+		Label:       *label,
+		Signature:   sig,
+	})
+}
+
+// the network, the sequence number email's next transaction has to be
+// signed for and the key it has to name, going by the node
+func (e *env) next(email string) (string, uint64, *rsa.PublicKey, error) {
+	status, err := e.api.Status()
+	if err != nil {
+		return "", 0, nil, err
+	}
+	cur, err := e.lookup(email, "")
+	if err != nil {
+		return "", 0, nil, err
+	}
+	if cur.Status != client.KeyActive {
+		return "", 0, nil, fmt.Errorf("%v is %v", email, cur.Status)
+	}
+	key, err := cur.PublicKey.PublicKey()
+	if err != nil {
+		return "", 0, nil, err
+	}
+	return status.ChainID, cur.Sequence + 1, key, nil
+}
+
+func keyHash(key *rsa.PublicKey) string {
+	hash := txn.KeyHash(key)
+	return hex.EncodeToString(hash[:])
+}
+
+// look email up (only its keys for purpose, if one is given) and check the
+// proof that comes with the answer
+func (e *env) lookup(email string, purpose string) (*client.KeyResponse, error) {
+	var res *client.KeyResponse
+	var err error
+	if purpose == "" {
+		res, err = e.api.Lookup(email)
+	} else {
+		res, err = e.api.LookupPurpose(email, purpose)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := client.VerifyKey(res); err != nil {
+		return nil, fmt.Errorf("node's answer doesn't check out: %v", err)
+	}
+	return res, nil
+}
+
+func cmdLookup(e *env, args []string) error {
+	fs := e.flags("lookup")
+	purpose := fs.String("purpose", "", "list only the keys for this purpose (sign, encrypt, manage)")
+	fs.Parse(args)
+	email, err := oneArg(fs, "email")
+	if err != nil {
+		return err
+	}
+	res, err := e.lookup(email, *purpose)
+	if err != nil {
+		return err
+	}
+	e.print(res, func() {
+		switch res.Status {
+		case client.KeyNotFound:
+			fmt.Printf("%v is not registered (proven as of block %v %v)\n", email, res.SeqNum, res.BlockHash)
+			return
+		case client.KeyRevoked:
+			fmt.Printf("%v was revoked in block %v (proven as of block %v %v)\n", email, res.RevokedAt, res.SeqNum, res.BlockHash)
+			return
+		}
+		fmt.Printf("%v, sequence %v (proven as of block %v %v):\n", email, res.Sequence, res.SeqNum, res.BlockHash)
+		if *purpose == "" {
+			printKey(res.PublicKey)
+		}
+		for _, k := range res.Keys {
+			if k.Label == txn.PrimaryLabel && *purpose == "" {
+				continue
+			}
+			fmt.Printf("key %q (%v):\n", k.Label, k.Purpose)
+			printKey(&k.PublicKey)
+		}
+		if res.RecoverAt != 0 {
+			fmt.Printf("recovery pending, taking over at block %v unless cancelled:\n", res.RecoverAt)
+			printKey(res.PendingKey)
+		}
+	})
+	return nil
+}
+
+func cmdVerify(e *env, args []string) error {
+	fs := e.flags("verify")
+	keyName := fs.String("key", "", "keystore key email should have")
+	fs.Parse(args)
+	email, err := oneArg(fs, "email")
+	if err != nil {
+		return err
+	}
+	if *keyName == "" {
+		return fmt.Errorf("-key is required")
+	}
+	ks, err := e.keystore()
+	if err != nil {
+		return err
+	}
+	priv, err := ks.Load(*keyName)
+	if err != nil {
+		return err
+	}
+	res, err := e.lookup(email, "")
+	if err != nil {
+		return err
+	}
+	match := false
+	if res.Status == client.KeyActive {
+		key, err := res.PublicKey.PublicKey()
+		if err != nil {
+			return err
+		}
+		match = key.E == priv.E && key.N.Cmp(priv.N) == 0
+	}
+	e.print(struct {
+		Email     string `json:"email"`
+		Key       string `json:"key"`
+		Match     bool   `json:"match"`
+		SeqNum    uint64 `json:"seq_num"`
+		BlockHash string `json:"block_hash"`
+	}{email, *keyName, match, res.SeqNum, res.BlockHash}, func() {
+		if match {
+			fmt.Printf("%v has key %v as of block %v\n", email, *keyName, res.SeqNum)
+		} else {
+			fmt.Printf("%v does NOT have key %v as of block %v\n", email, *keyName, res.SeqNum)
+		}
+	})
+	if !match {
+		return fmt.Errorf("key mismatch")
+	}
+	return nil
+}
+
+func cmdBlocks(e *env, args []string) error {
+	fs := e.flags("blocks")
+	hash := fs.String("hash", "", "fetch the block with this hex hash instead")
+	fs.Parse(args)
+
+	var blocks []*client.Block
+	if *hash != "" {
+		b, err := e.api.BlockByHash(*hash)
+		if err != nil {
+			return err
+		}
+		blocks = append(blocks, b)
+	} else {
+		from, to, err := e.blockRange(fs.Args())
+		if err != nil {
+			return err
+		}
+		for seq := from; seq <= to; seq++ {
+			b, err := e.api.Block(seq)
+			if err != nil {
+				return err
+			}
+			blocks = append(blocks, b)
+		}
+	}
+	e.print(blocks, func() {
+		for _, b := range blocks {
+			fmt.Printf("block %v %v\n", b.SeqNum, b.Hash)
+			fmt.Printf("  parent %v\n  merkle %v\n  state  %v\n", b.Header.ParentHash, b.Header.MerkleRoot, b.Header.StateRoot)
+			fmt.Printf("  time %v difficulty %v nonce %v\n", b.Header.Timestamp, b.Header.Difficulty, b.Header.Nonce)
+			for _, t := range b.Transactions {
+				fmt.Printf("  %v %v\n", t.Type, t.Email)
+			}
+		}
+	})
+	return nil
+}
+
+// from and to from the arguments; the tip alone if there are none
+func (e *env) blockRange(args []string) (uint64, uint64, error) {
+	switch len(args) {
+	case 0:
+		s, err := e.api.Status()
+		if err != nil {
+			return 0, 0, err
+		}
+		return s.TipSeqNum, s.TipSeqNum, nil
+	case 1, 2:
+		from, err := strconv.ParseUint(args[0], 10, 64)
+		if err != nil {
+			return 0, 0, fmt.Errorf("bad block number %q", args[0])
+		}
+		to := from
+		if len(args) == 2 {
+			if to, err = strconv.ParseUint(args[1], 10, 64); err != nil || to < from {
+				return 0, 0, fmt.Errorf("bad block number %q", args[1])
+			}
+		}
+		return from, to, nil
+	}
+	return 0, 0, fmt.Errorf("want at most two block numbers")
+}
+
+func cmdStatus(e *env, args []string) error {
+	fs := e.flags("status")
+	fs.Parse(args)
+	s, err := e.api.Status()
+	if err != nil {
+		return err
+	}
+	e.print(s, func() {
+		fmt.Printf("tip        %v %v\n", s.TipSeqNum, s.TipHash)
+		fmt.Printf("difficulty %v\n", s.Difficulty)
+		fmt.Printf("mempool    %v transactions\n", s.Mempool)
+		fmt.Printf("peers      %v\n", len(s.Peers))
+		for _, p := range s.Peers {
+			fmt.Printf("  %v\n", p)
+		}
+		if s.Sync.Syncing {
+			fmt.Printf("syncing from %v up to block %v\n", s.Sync.Peer, s.Sync.TargetSeqNum)
+		}
+		if s.Sync.LastError != "" {
+			fmt.Printf("last sync error: %v\n", s.Sync.LastError)
+		}
+	})
+	return nil
+}
+
+func cmdVectors(e *env, args []string) error {
+	fs := e.flags("vectors")
+	fs.Parse(args)
+	path, err := oneArg(fs, "vectors file")
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var vs chain.Vectors
+	if err := json.Unmarshal(data, &vs); err != nil {
+		return fmt.Errorf("%v: %v", path, err)
+	}
+	if err := vs.Check(); err != nil {
+		return err
+	}
+	fmt.Printf("%v: %v transactions, %v blocks and %v invalid encodings check out\n",
+		path, len(vs.Transactions), len(vs.Blocks), len(vs.Invalid))
+	return nil
+}
+
+func envOr(name string, def string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return def
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var keyName = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+// Keystore is a directory of private keys, one PKCS #1 PEM file per name,
+// readable only by the owner
+type Keystore struct {
+	dir string
+}
+
+func OpenKeystore(dir string) (*Keystore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &Keystore{dir}, nil
+}
+
+func (ks *Keystore) path(name string) (string, error) {
+	if !keyName.MatchString(name) {
+		return "", fmt.Errorf("bad key name %q", name)
+	}
+	return filepath.Join(ks.dir, name+".pem"), nil
+}
+
+// Generate makes a new key called name; it won't overwrite one
+func (ks *Keystore) Generate(name string, bits int) (*rsa.PrivateKey, error) {
+	path, err := ks.path(name)
+	if err != nil {
+		return nil, err
+	}
+	priv, err := rsa.GenerateKey(rand.Reader, bits)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if os.IsExist(err) {
+		return nil, fmt.Errorf("there is already a key called %v", name)
+	} else if err != nil {
+		return nil, err
+	}
+	err = pem.Encode(f, &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(priv)})
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(path)
+		return nil, err
+	}
+	return priv, nil
+}
+
+func (ks *Keystore) Load(name string) (*rsa.PrivateKey, error) {
+	path, err := ks.path(name)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("no key called %v", name)
+	} else if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "RSA PRIVATE KEY" {
+		return nil, fmt.Errorf("%v: not a PEM encoded RSA private key", path)
+	}
+	return x509.ParsePKCS1PrivateKey(block.Bytes)
+}
+
+// Names lists the keys, sorted
+func (ks *Keystore) Names() ([]string, error) {
+	files, err := ioutil.ReadDir(ks.dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, f := range files {
+		if name := strings.TrimSuffix(f.Name(), ".pem"); name != f.Name() && keyName.MatchString(name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
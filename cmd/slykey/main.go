@@ -0,0 +1,108 @@
+// slykey is the command line client for a SLYkey node's client API: it
+// keeps private keys in a local keystore, registers and updates keys, and
+// looks keys up with their proofs.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+type command struct {
+	usage string
+	help  string
+	run   func(env *env, args []string) error
+}
+
+var commands map[string]command
+
+// set up in init since the commands refer back to the table for usage
+func init() {
+	commands = map[string]command{
+		"keygen":          {"[-bits n] name", "generate a key pair into the keystore", cmdKeygen},
+		"keys":            {"", "list the keys in the keystore", cmdKeys},
+		"register":        {"-key name [-ca url] [-recovery name [-delay blocks]] email", "have the CA sign a registration of email and submit it", cmdRegister},
+		"update":          {"-old name -new name email", "move email to a new key, signed by the current one", cmdUpdate},
+		"revoke":          {"-key name email", "revoke email's key for good, signed by it or the recovery key", cmdRevoke},
+		"recover":         {"-recovery name -new name email", "move email to a new key with its recovery key, after the recovery delay", cmdRecover},
+		"cancel-recovery": {"-key name email", "cancel a pending recovery of email, signed by its current key", cmdCancelRecovery},
+		"add-key":         {"-signer name -key name -label label [-purpose purposes] email", "add a labelled key to email's key set", cmdAddKey},
+		"remove-key":      {"-signer name -label label email", "remove a labelled key from email's key set", cmdRemoveKey},
+		"lookup":          {"[-purpose purpose] email", "look up email's keys and check the node's proof", cmdLookup},
+		"verify":          {"-key name email", "check that email's proven key is the keystore key", cmdVerify},
+		"blocks":          {"[-hash hash] [from [to]]", "dump blocks of the node's chain", cmdBlocks},
+		"status":          {"", "show the node's status", cmdStatus},
+		"vectors":         {"file", "check the encoding test vectors in file", cmdVectors},
+	}
+}
+
+func defaultHome() string {
+	if home := os.Getenv("SLYKEY_HOME"); home != "" {
+		return home
+	}
+	dir, err := os.UserHomeDir()
+	if err != nil {
+		return ".slykey"
+	}
+	return filepath.Join(dir, ".slykey")
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: slykey [flags] command [args]\n\nflags:\n")
+	flag.PrintDefaults()
+	fmt.Fprintf(os.Stderr, "\ncommands:\n")
+	var names []string
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		c := commands[name]
+		fmt.Fprintf(os.Stderr, "  %v %v\n    \t%v\n", name, c.usage, c.help)
+	}
+}
+
+func main() {
+	node := flag.String("node", envOr("SLYKEY_NODE", "http://127.0.0.1:8081"), "client API address of the node (or $SLYKEY_NODE)")
+	home := flag.String("keystore", filepath.Join(defaultHome(), "keys"), "keystore directory (under $SLYKEY_HOME)")
+	format := flag.String("format", "pem", "key format to print, pem or jwk")
+	asJSON := flag.Bool("json", false, "print results as JSON")
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+	c, ok := commands[flag.Arg(0)]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "slykey: unknown command %q\n", flag.Arg(0))
+		usage()
+		os.Exit(2)
+	}
+	e := newEnv(*node, *home, *format, *asJSON)
+	if err := c.run(e, flag.Args()[1:]); err != nil {
+		if e.json {
+			e.print(map[string]string{"error": err.Error()}, nil)
+		}
+		fmt.Fprintf(os.Stderr, "slykey %v: %v\n", flag.Arg(0), err)
+		os.Exit(1)
+	}
+}
+
+// print writes v as JSON with -json, or calls text otherwise
+func (e *env) print(v interface{}, text func()) {
+	if e.json {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(v)
+		return
+	}
+	if text != nil {
+		text()
+	}
+}
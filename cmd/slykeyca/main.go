@@ -0,0 +1,40 @@
+// slykeyca runs the certificate authority (see package ca) over HTTP.
+package main
+
+import (
+	"flag"
+	"log"
+	"strconv"
+
+	"github.com/tslilyai/SLYkey/ca"
+	"github.com/tslilyai/SLYkey/chain"
+)
+
+var (
+	port        = flag.Int("port", 8080, "HTTP port")
+	privKeyFile = flag.String("key", "rsa_pub", "Private Key File")
+	network     = flag.String("network", "main", "network to sign registrations for, main or test")
+)
+
+func main() {
+	// showing the source file and line number where the log statement comes from
+	log.SetFlags(log.LstdFlags | log.Lshortfile)
+
+	flag.Parse()
+
+	params := chain.MainNetParams
+	if *network == "test" {
+		params = chain.TestNetParams
+	} else if *network != "main" {
+		log.Fatalf("unknown network %q", *network)
+	}
+	app, err := ca.NewApp(":"+strconv.Itoa(*port), *privKeyFile, params.ChainID)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = app.Run()
+	if err != nil {
+		log.Fatal(err)
+	}
+}
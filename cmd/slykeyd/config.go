@@ -0,0 +1,226 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// DaemonConfig is what slykeyd runs with. It comes from, in increasing
+// order of precedence: the defaults, the config file, SLYKEYD_* environment
+// variables, and command line flags.
+type DaemonConfig struct {
+	Listen      string // RPC address for other nodes
+	Transport   string // "tcp" or "unix"
+	API         string // client API host:port, "" for none
+	DataDir     string
+	Seeds       []string
+	TargetPeers int
+	Network     string // "main" or "test"
+	// overrides the network's starting difficulty when nonzero; nodes that
+	// disagree on it are on different chains. It can't go below the
+	// network's MinDifficulty.
+	InitialDifficulty uint64
+	CAKey             string // the CA's public key (PEM file)
+	CAURL             string // where RegisterPublicKey gets registrations signed
+	TLSCert           string
+	TLSKey            string
+	TLSRoots          []string
+}
+
+func DefaultDaemonConfig() DaemonConfig {
+	return DaemonConfig{
+		Listen:      "127.0.0.1:7000",
+		Transport:   "tcp",
+		API:         "127.0.0.1:8081",
+		DataDir:     "slykey-data",
+		TargetPeers: 8,
+		Network:     "main",
+	}
+}
+
+// one setting: its name in the config file ("section.key"), and how to set
+// it from the values given
+type configOption struct {
+	key  string
+	list bool
+	help string
+	set  func(c *DaemonConfig, vals []string) error
+}
+
+func setString(field func(c *DaemonConfig) *string) func(*DaemonConfig, []string) error {
+	return func(c *DaemonConfig, vals []string) error {
+		*field(c) = vals[0]
+		return nil
+	}
+}
+
+func setList(field func(c *DaemonConfig) *[]string) func(*DaemonConfig, []string) error {
+	return func(c *DaemonConfig, vals []string) error {
+		*field(c) = vals
+		return nil
+	}
+}
+
+var configOptions = []configOption{
+	{"node.listen", false, "address other nodes reach us on", setString(func(c *DaemonConfig) *string { return &c.Listen })},
+	{"node.transport", false, "tcp or unix", func(c *DaemonConfig, vals []string) error {
+		if vals[0] != "tcp" && vals[0] != "unix" {
+			return fmt.Errorf("transport must be tcp or unix, not %q", vals[0])
+		}
+		c.Transport = vals[0]
+		return nil
+	}},
+	{"node.api", false, "client API host:port, empty for none", setString(func(c *DaemonConfig) *string { return &c.API })},
+	{"node.data_dir", false, "where the chain, snapshots, peers and PID file go", setString(func(c *DaemonConfig) *string { return &c.DataDir })},
+	{"node.seeds", true, "addresses to find the network through", setList(func(c *DaemonConfig) *[]string { return &c.Seeds })},
+	{"node.target_peers", false, "how many peers to stay connected to", func(c *DaemonConfig, vals []string) error {
+		n, err := strconv.Atoi(vals[0])
+		if err != nil || n <= 0 {
+			return fmt.Errorf("bad peer count %q", vals[0])
+		}
+		c.TargetPeers = n
+		return nil
+	}},
+	{"node.network", false, "main or test", func(c *DaemonConfig, vals []string) error {
+		if vals[0] != "main" && vals[0] != "test" {
+			return fmt.Errorf("network must be main or test, not %q", vals[0])
+		}
+		c.Network = vals[0]
+		return nil
+	}},
+	{"node.initial_difficulty", false, "starting difficulty, 0 for the network's; at least its minimum", func(c *DaemonConfig, vals []string) error {
+		n, err := strconv.ParseUint(vals[0], 10, 64)
+		if err != nil {
+			return fmt.Errorf("bad difficulty %q", vals[0])
+		}
+		c.InitialDifficulty = n
+		return nil
+	}},
+	{"node.ca_key", false, "CA public key (PEM) registrations must be signed with", setString(func(c *DaemonConfig) *string { return &c.CAKey })},
+	{"node.ca_url", false, "CA URL the node gets registrations signed at, empty for none", setString(func(c *DaemonConfig) *string { return &c.CAURL })},
+	{"tls.cert", false, "node certificate (PEM), enables mutual TLS", setString(func(c *DaemonConfig) *string { return &c.TLSCert })},
+	{"tls.key", false, "node certificate key (PEM)", setString(func(c *DaemonConfig) *string { return &c.TLSKey })},
+	{"tls.roots", true, "CA certificates (PEM) peers must be signed by", setList(func(c *DaemonConfig) *[]string { return &c.TLSRoots })},
+}
+
+func findOption(key string) (configOption, bool) {
+	for _, o := range configOptions {
+		if o.key == key {
+			return o, true
+		}
+	}
+	return configOption{}, false
+}
+
+// the flag for an option: node.data_dir is -data-dir, tls.cert is -tls-cert
+func (o configOption) flagName() string {
+	return strings.NewReplacer(".", "-", "_", "-").Replace(strings.TrimPrefix(o.key, "node."))
+}
+
+// and its environment variable: SLYKEYD_DATA_DIR, SLYKEYD_TLS_CERT
+func (o configOption) envName() string {
+	return "SLYKEYD_" + strings.ToUpper(strings.Replace(strings.TrimPrefix(o.key, "node."), ".", "_", -1))
+}
+
+// Set sets an option from a flag or environment variable, where lists are
+// comma separated
+func (c *DaemonConfig) Set(key string, raw string) error {
+	o, ok := findOption(key)
+	if !ok {
+		return fmt.Errorf("unknown setting %v", key)
+	}
+	vals := []string{raw}
+	if o.list {
+		vals = nil
+		for _, v := range strings.Split(raw, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				vals = append(vals, v)
+			}
+		}
+	}
+	return o.set(c, vals)
+}
+
+// LoadFile reads a TOML config file (see slykeyd.example.toml): a [node]
+// and a [tls] table holding the settings in configOptions by their short
+// names
+func (c *DaemonConfig) LoadFile(path string) error {
+	var file map[string]interface{}
+	if _, err := toml.DecodeFile(path, &file); err != nil {
+		return fmt.Errorf("%v: %v", path, err)
+	}
+	return c.load(file, path)
+}
+
+func (c *DaemonConfig) load(file map[string]interface{}, name string) error {
+	// in order, so a file with several mistakes always reports the same one
+	for _, section := range sortedKeys(file) {
+		table, ok := file[section].(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%v: %v has to be in a [section]", name, section)
+		}
+		for _, k := range sortedKeys(table) {
+			key := section + "." + k
+			o, ok := findOption(key)
+			if !ok {
+				return fmt.Errorf("%v: unknown setting %v", name, key)
+			}
+			vals, err := o.values(table[k])
+			if err == nil {
+				err = o.set(c, vals)
+			}
+			if err != nil {
+				return fmt.Errorf("%v: %v: %v", name, key, err)
+			}
+		}
+	}
+	return nil
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// values turns what the TOML decoder made of o's value into the strings
+// set takes, as if they came from a flag
+func (o configOption) values(v interface{}) ([]string, error) {
+	if list, ok := v.([]interface{}); ok {
+		if !o.list {
+			return nil, fmt.Errorf("wants a single value")
+		}
+		vals := make([]string, 0, len(list))
+		for _, e := range list {
+			s, ok := e.(string)
+			if !ok {
+				return nil, fmt.Errorf("wants a list of strings")
+			}
+			vals = append(vals, s)
+		}
+		return vals, nil
+	}
+	if o.list {
+		return nil, fmt.Errorf("wants a list")
+	}
+	switch v := v.(type) {
+	case string:
+		return []string{v}, nil
+	case int64:
+		return []string{strconv.FormatInt(v, 10)}, nil
+	}
+	return nil, fmt.Errorf("wants a string or a number")
+}
+
+// the data directory paths
+func (c *DaemonConfig) path(name string) string {
+	return filepath.Join(c.DataDir, name)
+}
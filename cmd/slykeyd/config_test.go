@@ -0,0 +1,31 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"testing"
+)
+
+func TestFlagNames(t *testing.T) {
+	fs := flag.NewFlagSet("slykeyd", flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	flags := optionFlags(fs)
+	var args []string
+	for _, o := range configOptions {
+		args = append(args, "-"+o.flagName(), o.key)
+	}
+	if err := fs.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	for _, o := range configOptions {
+		if flags[o.key] != o.key {
+			t.Errorf("-%v didn't set %v", o.flagName(), o.key)
+		}
+	}
+	// the names the docs give
+	for _, name := range []string{"data-dir", "tls-cert", "tls-key", "tls-roots", "ca-url"} {
+		if fs.Lookup(name) == nil {
+			t.Errorf("no flag -%v", name)
+		}
+	}
+}
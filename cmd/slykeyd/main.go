@@ -0,0 +1,180 @@
+package main
+
+import (
+	"crypto/rsa"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+
+	"github.com/tslilyai/SLYkey/chain"
+	"github.com/tslilyai/SLYkey/client"
+	"github.com/tslilyai/SLYkey/node"
+	"github.com/tslilyai/SLYkey/rpc"
+)
+
+// slykeyd: run a node. See DaemonConfig for the settings; every one of
+// them is also a flag and a SLYKEYD_* environment variable.
+func main() {
+	log.SetFlags(log.LstdFlags | log.Lshortfile)
+
+	configPath := flag.String("config", os.Getenv("SLYKEYD_CONFIG"), "config file (or $SLYKEYD_CONFIG)")
+	flags := optionFlags(flag.CommandLine)
+	flag.Parse()
+
+	cfg := DefaultDaemonConfig()
+	if *configPath != "" {
+		if err := cfg.LoadFile(*configPath); err != nil {
+			log.Fatal(err)
+		}
+	}
+	for _, o := range configOptions {
+		if v := os.Getenv(o.envName()); v != "" {
+			if err := cfg.Set(o.key, v); err != nil {
+				log.Fatalf("$%v: %v", o.envName(), err)
+			}
+		}
+	}
+	for _, o := range configOptions {
+		if v, ok := flags[o.key]; ok {
+			if err := cfg.Set(o.key, v); err != nil {
+				log.Fatalf("-%v: %v", o.flagName(), err)
+			}
+		}
+	}
+
+	if err := runDaemon(&cfg); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// optionFlags defines a flag on fs for every setting, and returns where
+// parsing puts the ones given, by setting
+func optionFlags(fs *flag.FlagSet) map[string]string {
+	flags := make(map[string]string)
+	for _, o := range configOptions {
+		o := o
+		help := fmt.Sprintf("%v (or $%v)", o.help, o.envName())
+		fs.Func(o.flagName(), help, func(v string) error {
+			flags[o.key] = v
+			return nil
+		})
+	}
+	return flags
+}
+
+func runDaemon(cfg *DaemonConfig) error {
+	params := chain.MainNetParams
+	if cfg.Network == "test" {
+		params = chain.TestNetParams
+	}
+	if cfg.InitialDifficulty != 0 {
+		// blocks below the minimum are invalid, our own included, so such a
+		// node could never get past genesis
+		if cfg.InitialDifficulty < params.MinDifficulty {
+			return fmt.Errorf("initial difficulty %v is below the %v network's minimum of %v",
+				cfg.InitialDifficulty, cfg.Network, params.MinDifficulty)
+		}
+		params.InitialDifficulty = cfg.InitialDifficulty
+	}
+	var caKey *rsa.PublicKey
+	if cfg.CAKey != "" {
+		data, err := ioutil.ReadFile(cfg.CAKey)
+		if err != nil {
+			return err
+		}
+		if caKey, err = (&client.Key{PEM: string(data)}).PublicKey(); err != nil {
+			return fmt.Errorf("%v: %v", cfg.CAKey, err)
+		}
+	}
+
+	if err := os.MkdirAll(cfg.DataDir, 0700); err != nil {
+		return err
+	}
+	// one daemon per data directory
+	unlock, err := lockPIDFile(cfg.path("slykeyd.pid"))
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	store, err := chain.OpenFileBlockStore(cfg.path("blocks.log"), params.Genesis())
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	snaps, err := chain.OpenSnapshotStore(cfg.path("snapshots"))
+	if err != nil {
+		return err
+	}
+	peerDB, err := node.OpenPeerDB(cfg.path("peers.json"))
+	if err != nil {
+		return err
+	}
+	var transport rpc.Transport = rpc.UnixTransport{}
+	if cfg.Transport == "tcp" {
+		t := rpc.TCPTransport{}
+		if cfg.TLSCert != "" {
+			if t.TLS, err = rpc.LoadTLSConfig(cfg.TLSCert, cfg.TLSKey, cfg.TLSRoots); err != nil {
+				return err
+			}
+		}
+		transport = t
+	}
+
+	ns, err := node.NewNodeServer(node.NodeConfig{
+		Addr:        cfg.Listen,
+		Params:      &params,
+		Seeds:       cfg.Seeds,
+		PeerDB:      peerDB,
+		TargetPeers: cfg.TargetPeers,
+		Store:       store,
+		Snapshots:   snaps,
+		Transport:   transport,
+		APIAddr:     cfg.API,
+		CAKey:       caKey,
+		CAURL:       cfg.CAURL,
+	})
+	if err != nil {
+		return err
+	}
+	log.Printf("slykeyd %v listening on %v (%v), client API on %v, data in %v",
+		cfg.Network, cfg.Listen, cfg.Transport, cfg.API, cfg.DataDir)
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGTERM, syscall.SIGINT)
+	sig := <-sigs
+	log.Printf("got %v, shutting down", sig)
+	ns.Shutdown()
+	return nil
+}
+
+// lockPIDFile takes an exclusive lock on path and writes our PID into it.
+// The lock goes away with the process, so a crash doesn't leave a stale one
+// behind; the returned func releases it and removes the file.
+func lockPIDFile(path string) (func(), error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%v is locked; is another slykeyd using this data directory?", path)
+	}
+	if err := f.Truncate(0); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if _, err := f.WriteString(strconv.Itoa(os.Getpid()) + "\n"); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return func() {
+		os.Remove(path)
+		f.Close()
+	}, nil
+}
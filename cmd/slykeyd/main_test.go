@@ -0,0 +1,38 @@
+package main
+
+import (
+	"net"
+	"os"
+	"testing"
+
+	"github.com/tslilyai/SLYkey/chain"
+)
+
+func TestInitialDifficultyAboveMinimum(t *testing.T) {
+	cfg := DefaultDaemonConfig()
+	cfg.DataDir = t.TempDir()
+	cfg.InitialDifficulty = chain.MainNetParams.MinDifficulty / 64
+	if err := runDaemon(&cfg); err == nil {
+		t.Fatal("ran main network rules below their minimum difficulty")
+	}
+}
+
+// a node that can't start hands back the error, and lets go of its data
+// directory on the way out
+func TestAPIListenFailure(t *testing.T) {
+	busy, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer busy.Close()
+	cfg := DefaultDaemonConfig()
+	cfg.DataDir = t.TempDir()
+	cfg.Listen = "127.0.0.1:0"
+	cfg.API = busy.Addr().String()
+	if err := runDaemon(&cfg); err == nil {
+		t.Fatal("started with the client API address taken")
+	}
+	if _, err := os.Stat(cfg.path("slykeyd.pid")); !os.IsNotExist(err) {
+		t.Fatalf("PID file left behind: %v", err)
+	}
+}
@@ -0,0 +1,41 @@
+// slykeysim runs the simulator's scenarios (see package sim) and reports
+// which ones the network got through.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"time"
+
+	"github.com/tslilyai/SLYkey/sim"
+)
+
+func main() {
+	seed := flag.Int64("seed", 1, "seed for everything random in the simulation")
+	run := flag.String("run", "", "only run the scenario with this name")
+	verbose := flag.Bool("v", false, "show the nodes' logs")
+	flag.Parse()
+	if !*verbose {
+		log.SetOutput(ioutil.Discard)
+	}
+
+	failed := false
+	for _, sc := range sim.Scenarios {
+		if *run != "" && sc.Name != *run {
+			continue
+		}
+		start := time.Now()
+		if err := sim.RunScenario(sc, *seed); err != nil {
+			fmt.Printf("FAIL %v (%v): %v\n", sc.Name, time.Since(start).Round(time.Millisecond), err)
+			failed = true
+		} else {
+			fmt.Printf("ok   %v (%v)\n", sc.Name, time.Since(start).Round(time.Millisecond))
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
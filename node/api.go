@@ -0,0 +1,371 @@
+package node
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/tslilyai/SLYkey/chain"
+	"github.com/tslilyai/SLYkey/client"
+	"github.com/tslilyai/SLYkey/txn"
+)
+
+const (
+	// biggest request body we read
+	MaxRequestBody = 1 << 20
+)
+
+// StartAPIServer serves the client API (schema in package client) over
+// plain HTTP on addr, a host:port
+func (ns *NodeServer) StartAPIServer(addr string) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc(client.PathKeys, ns.handleKey)
+	mux.HandleFunc(client.PathTransactions, ns.handleTransaction)
+	mux.HandleFunc(client.PathBlocks, ns.handleBlock)
+	mux.HandleFunc(client.PathBlocksByHash, ns.handleBlockByHash)
+	mux.HandleFunc(client.PathStatus, ns.handleStatus)
+
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	ns.apiServer = &http.Server{Handler: mux}
+	go ns.apiServer.Serve(l)
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, code string, msg string) {
+	writeJSON(w, status, client.ErrorResponse{Error: client.Error{Code: code, Message: msg}})
+}
+
+func requireMethod(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method != method {
+		writeError(w, http.StatusMethodNotAllowed, client.CodeMethod, "must use "+method)
+		return false
+	}
+	return true
+}
+
+// the key format asked for, checked up front
+func keyFormat(w http.ResponseWriter, r *http.Request) (string, bool) {
+	format := r.URL.Query().Get("format")
+	switch format {
+	case "":
+		return client.FormatPEM, true
+	case client.FormatPEM, client.FormatJWK:
+		return format, true
+	}
+	writeError(w, http.StatusBadRequest, client.CodeBadRequest, "unknown key format "+format)
+	return "", false
+}
+
+// GET /v1/keys/<email>[?purpose=sign,...]
+func (ns *NodeServer) handleKey(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodGet) {
+		return
+	}
+	format, ok := keyFormat(w, r)
+	if !ok {
+		return
+	}
+	purpose, err := txn.ParsePurpose(r.URL.Query().Get("purpose"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, client.CodeBadRequest, err.Error())
+		return
+	}
+	email := strings.TrimPrefix(r.URL.Path, client.PathKeys)
+	if email == "" {
+		writeError(w, http.StatusBadRequest, client.CodeBadRequest, "no email")
+		return
+	}
+	kp := ns.LookupKey(email)
+	proof, err := json.Marshal(&kp)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, client.CodeInternal, err.Error())
+		return
+	}
+	hash := kp.Header.Hash()
+	// the proof has the identity as stored; the answer is as of the tip
+	id := txn.Settle(&kp.Lookup.Identity, kp.SeqNum)
+	resp := client.KeyResponse{
+		Email:         email,
+		Found:         kp.Lookup.Found,
+		Status:        client.KeyStatus(kp.Lookup.Found, id.Revoked),
+		RevokedAt:     id.RevokedAt,
+		Sequence:      id.Sequence,
+		RecoveryDelay: id.RecoveryDelay,
+		RecoverAt:     id.RecoverAt,
+		Purpose:       purpose.String(),
+		SeqNum:        kp.SeqNum,
+		BlockHash:     hex.EncodeToString(hash[:]),
+		Proof:         proof,
+	}
+	if resp.Status == client.KeyActive {
+		if resp.PublicKey, err = client.NewKey(&id.PublicKey, format); err != nil {
+			writeError(w, http.StatusInternalServerError, client.CodeInternal, err.Error())
+			return
+		}
+		for _, k := range txn.WithPurpose(id.KeySet(), purpose) {
+			key, err := client.NewKey(&k.PublicKey, format)
+			if err != nil {
+				writeError(w, http.StatusInternalServerError, client.CodeInternal, err.Error())
+				return
+			}
+			resp.Keys = append(resp.Keys, client.IdentityKey{Label: k.Label, Purpose: k.Purpose.String(), PublicKey: *key})
+		}
+	}
+	if id.RecoverAt != 0 {
+		if resp.PendingKey, err = client.NewKey(&id.PendingKey, format); err != nil {
+			writeError(w, http.StatusInternalServerError, client.CodeInternal, err.Error())
+			return
+		}
+	}
+	// a lookup that proves the email isn't registered (or was revoked) is
+	// still an answer
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// POST /v1/transactions
+func (ns *NodeServer) handleTransaction(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodPost) {
+		return
+	}
+	var ct client.Transaction
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, MaxRequestBody)).Decode(&ct); err != nil {
+		writeError(w, http.StatusBadRequest, client.CodeBadRequest, "bad transaction json: "+err.Error())
+		return
+	}
+	t, err := txnFromAPI(&ct)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, client.CodeBadRequest, err.Error())
+		return
+	}
+	switch err := ns.SubmitTransaction(t); err {
+	case nil:
+		hash := t.Hash()
+		writeJSON(w, http.StatusAccepted, client.SubmitResponse{Hash: hex.EncodeToString(hash[:])})
+	case ErrDuplicateTxn:
+		writeError(w, http.StatusConflict, client.CodeDuplicateTxn, err.Error())
+	case ErrConflictingTxn:
+		writeError(w, http.StatusConflict, client.CodeConflictingTxn, err.Error())
+	case ErrMempoolFull:
+		writeError(w, http.StatusServiceUnavailable, client.CodeMempoolFull, err.Error())
+	default:
+		writeError(w, http.StatusUnprocessableEntity, client.CodeInvalidTxn, err.Error())
+	}
+}
+
+// GET /v1/blocks/<seqnum>, from our main chain
+func (ns *NodeServer) handleBlock(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodGet) {
+		return
+	}
+	format, ok := keyFormat(w, r)
+	if !ok {
+		return
+	}
+	seqNum, err := strconv.ParseUint(strings.TrimPrefix(r.URL.Path, client.PathBlocks), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, client.CodeBadRequest, "bad block number")
+		return
+	}
+	ns.mMu.Lock()
+	b, found := ns.chain.Get(seqNum)
+	ns.mMu.Unlock()
+	ns.writeBlock(w, b, found, format)
+}
+
+// GET /v1/blocks/hash/<hex hash>, from any branch we know of
+func (ns *NodeServer) handleBlockByHash(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodGet) {
+		return
+	}
+	format, ok := keyFormat(w, r)
+	if !ok {
+		return
+	}
+	raw, err := hex.DecodeString(strings.TrimPrefix(r.URL.Path, client.PathBlocksByHash))
+	if err != nil || len(raw) != sha256.Size {
+		writeError(w, http.StatusBadRequest, client.CodeBadRequest, "bad block hash")
+		return
+	}
+	var hash [sha256.Size]byte
+	copy(hash[:], raw)
+	ns.mMu.Lock()
+	b, found := ns.tree.Get(hash)
+	ns.mMu.Unlock()
+	ns.writeBlock(w, b, found, format)
+}
+
+func (ns *NodeServer) writeBlock(w http.ResponseWriter, b chain.Block, found bool, format string) {
+	if !found {
+		writeError(w, http.StatusNotFound, client.CodeNotFound, "no such block")
+		return
+	}
+	cb, err := blockToAPI(&b, format)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, client.CodeInternal, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, cb)
+}
+
+// GET /v1/status
+func (ns *NodeServer) handleStatus(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodGet) {
+		return
+	}
+	ns.mMu.Lock()
+	tip := ns.tree.Tip()
+	ns.mMu.Unlock()
+	sync := ns.SyncStatus()
+	peers := ns.Peers()
+	if peers == nil {
+		peers = []string{}
+	}
+	writeJSON(w, http.StatusOK, client.Status{
+		ChainID:    ns.params.ChainID,
+		TipSeqNum:  tip.SeqNum,
+		TipHash:    hex.EncodeToString(tip.Hash[:]),
+		Difficulty: tip.Difficulty,
+		Peers:      peers,
+		Mempool:    ns.mempool.Len(),
+		Sync: client.SyncStatus{
+			Syncing:      sync.Syncing,
+			Peer:         sync.Peer,
+			TargetSeqNum: sync.TargetSeqNum,
+			LastError:    sync.LastError,
+		},
+	})
+}
+
+func txnToAPI(t *txn.Transaction, format string) (client.Transaction, error) {
+	ct := client.Transaction{Email: t.Email, Sequence: t.Sequence, Nonce: t.Nonce, Label: t.Label, Signature: t.Signature}
+	if t.Purpose != 0 {
+		ct.Purpose = t.Purpose.String()
+	}
+	if t.Type != txn.Register {
+		ct.PrevKeyHash = hex.EncodeToString(t.PrevKeyHash[:])
+	}
+	switch t.Type {
+	case txn.Register:
+		ct.Type = client.TxnRegister
+		if t.RecoveryKey.N != nil {
+			key, err := client.NewKey(&t.RecoveryKey, format)
+			if err != nil {
+				return ct, err
+			}
+			ct.RecoveryKey, ct.RecoveryDelay = key, t.RecoveryDelay
+		}
+	case txn.Update:
+		ct.Type = client.TxnUpdate
+	case txn.Recover:
+		ct.Type = client.TxnRecover
+	case txn.Revoke:
+		ct.Type = client.TxnRevoke
+		// no key to show
+		return ct, nil
+	case txn.CancelRecovery:
+		ct.Type = client.TxnCancelRecovery
+		return ct, nil
+	case txn.AddKey:
+		ct.Type = client.TxnAddKey
+	case txn.RemoveKey:
+		ct.Type = client.TxnRemoveKey
+		return ct, nil
+	}
+	key, err := client.NewKey(&t.PublicKey, format)
+	if err != nil {
+		return ct, err
+	}
+	ct.PublicKey = *key
+	return ct, nil
+}
+
+func txnFromAPI(ct *client.Transaction) (txn.Transaction, error) {
+	t := txn.Transaction{Email: ct.Email, Sequence: ct.Sequence, Nonce: ct.Nonce, Label: ct.Label, Signature: ct.Signature}
+	purpose, err := txn.ParsePurpose(ct.Purpose)
+	if err != nil {
+		return t, &client.Error{Code: client.CodeBadRequest, Message: err.Error()}
+	}
+	t.Purpose = purpose
+	if ct.PrevKeyHash != "" {
+		prev, err := hex.DecodeString(ct.PrevKeyHash)
+		if err != nil || len(prev) != len(t.PrevKeyHash) {
+			return t, &client.Error{Code: client.CodeBadRequest, Message: "bad prev_key_hash"}
+		}
+		copy(t.PrevKeyHash[:], prev)
+	}
+	if ct.RecoveryKey != nil {
+		key, err := ct.RecoveryKey.PublicKey()
+		if err != nil {
+			return t, err
+		}
+		t.RecoveryKey, t.RecoveryDelay = *key, ct.RecoveryDelay
+	}
+	switch ct.Type {
+	case client.TxnRegister:
+		t.Type = txn.Register
+	case client.TxnUpdate:
+		t.Type = txn.Update
+	case client.TxnRecover:
+		t.Type = txn.Recover
+	case client.TxnRevoke:
+		t.Type = txn.Revoke
+	case client.TxnCancelRecovery:
+		t.Type = txn.CancelRecovery
+	case client.TxnAddKey:
+		t.Type = txn.AddKey
+	case client.TxnRemoveKey:
+		t.Type = txn.RemoveKey
+	default:
+		return t, &client.Error{Code: client.CodeBadRequest, Message: "unknown transaction type " + ct.Type}
+	}
+	if t.Type == txn.Revoke || t.Type == txn.CancelRecovery || t.Type == txn.RemoveKey {
+		if ct.PublicKey.PEM != "" || ct.PublicKey.JWK != nil {
+			return t, &client.Error{Code: client.CodeBadRequest, Message: ct.Type + " has no public key"}
+		}
+		return t, nil
+	}
+	key, err := ct.PublicKey.PublicKey()
+	if err != nil {
+		return t, err
+	}
+	t.PublicKey = *key
+	return t, nil
+}
+
+func blockToAPI(b *chain.Block, format string) (client.Block, error) {
+	cb := client.Block{
+		SeqNum: b.SeqNum,
+		Hash:   hex.EncodeToString(b.Hash[:]),
+		Header: client.Header{
+			Version:    b.Version,
+			ParentHash: hex.EncodeToString(b.ParentHash[:]),
+			MerkleRoot: hex.EncodeToString(b.MerkleRoot[:]),
+			StateRoot:  hex.EncodeToString(b.StateRoot[:]),
+			Timestamp:  b.Timestamp,
+			Difficulty: b.Difficulty,
+			Nonce:      b.Nonce,
+		},
+		Transactions: []client.Transaction{},
+	}
+	for i := range b.Transactions {
+		ct, err := txnToAPI(&b.Transactions[i], format)
+		if err != nil {
+			return cb, err
+		}
+		cb.Transactions = append(cb.Transactions, ct)
+	}
+	return cb, nil
+}
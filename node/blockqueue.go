@@ -1,4 +1,8 @@
-package main
+package node
+
+import (
+	"github.com/tslilyai/SLYkey/chain"
+)
 
 const (
 	MAX_QUEUE = 64
@@ -8,7 +12,7 @@ const (
 // so this is our queue...
 // Many thanks to https://gist.github.com/moraes/2141121
 type BlockQueue struct {
-	queue []Block
+	queue []chain.Block
 	head  uint64
 	tail  uint64
 	size  uint64
@@ -17,7 +21,7 @@ type BlockQueue struct {
 
 func NewBlockQueue(initSize uint64) *BlockQueue {
 	ret := &BlockQueue{}
-	ret.queue = make([]Block, initSize)
+	ret.queue = make([]chain.Block, initSize)
 	ret.head = 0
 	ret.tail = 0
 	ret.size = initSize
@@ -29,9 +33,9 @@ func (bq *BlockQueue) Count() uint64 {
 	return bq.count
 }
 
-func (bq *BlockQueue) Push(b Block) {
+func (bq *BlockQueue) Push(b chain.Block) {
 	if bq.head == bq.tail && bq.count > 0 {
-		realloc := make([]Block, uint64(len(bq.queue))+bq.size)
+		realloc := make([]chain.Block, uint64(len(bq.queue))+bq.size)
 		copy(realloc, bq.queue[bq.head:])
 		copy(realloc[uint64(len(bq.queue))-bq.head:], bq.queue[:bq.head])
 		bq.head = 0
@@ -43,9 +47,9 @@ func (bq *BlockQueue) Push(b Block) {
 	bq.count++
 }
 
-func (bq *BlockQueue) Pop() Block {
+func (bq *BlockQueue) Pop() chain.Block {
 	if bq.count == 0 {
-		return Block{}
+		return chain.Block{}
 	}
 	b := bq.queue[bq.head]
 	bq.head = (bq.head + 1) % uint64(len(bq.queue))
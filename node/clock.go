@@ -0,0 +1,24 @@
+package node
+
+import (
+	"time"
+)
+
+// Clock is where a node gets the time from: for block timestamps, and for
+// everything it waits on. The simulator (package sim) runs nodes on a clock
+// it moves itself.
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+// RealClock is the wall clock
+type RealClock struct{}
+
+func (RealClock) Now() time.Time {
+	return time.Now()
+}
+
+func (RealClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
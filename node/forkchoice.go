@@ -0,0 +1,316 @@
+package node
+
+import (
+	"crypto/sha256"
+	"log"
+	"math/big"
+
+	"github.com/tslilyai/SLYkey/chain"
+	"github.com/tslilyai/SLYkey/txn"
+)
+
+const (
+	// blocks we hold on to while waiting for their parents
+	MAX_ORPHANS = 256
+)
+
+type treeNode struct {
+	block    chain.Block
+	parent   *treeNode
+	children []*treeNode
+	work     *big.Int // cumulative work from genesis through this block
+	arrival  uint64   // how many blocks the tree took in before this one
+	// old directory values of the emails this block changed, recorded when
+	// the block is applied; nil if it isn't on the main chain or was
+	// applied before we booted
+	undo chain.Undo
+}
+
+// BlockTree holds every block we know about that passed the sanity check,
+// competing branches included, keyed by hash. The main chain (what is in
+// block store and directory) always ends at tip; we move tip to whichever
+// block has the most cumulative work.
+type BlockTree struct {
+	nodes   map[[sha256.Size]byte]*treeNode
+	orphans map[[sha256.Size]byte][]chain.Block // keyed by the parent they wait on
+	nOrphan int
+	added   uint64 // blocks taken in so far, for treeNode.arrival
+	tip     *treeNode
+	params  *chain.ChainParams
+}
+
+// NewBlockTree builds a tree holding just the main chain in store, which
+// follows params
+func NewBlockTree(store chain.BlockStore, params *chain.ChainParams) *BlockTree {
+	t := &BlockTree{
+		nodes:   make(map[[sha256.Size]byte]*treeNode),
+		orphans: make(map[[sha256.Size]byte][]chain.Block),
+		params:  params,
+	}
+	var prev *treeNode
+	store.ForEach(func(b chain.Block) bool {
+		if prev == nil {
+			// genesis: nothing to compete with
+			prev = &treeNode{block: b, work: new(big.Int)}
+			t.nodes[b.Hash] = prev
+			return true
+		}
+		prev = t.add(b, prev)
+		return true
+	})
+	t.tip = prev
+	return t
+}
+
+func (t *BlockTree) Has(hash [sha256.Size]byte) bool {
+	_, ok := t.nodes[hash]
+	return ok
+}
+
+func (t *BlockTree) Get(hash [sha256.Size]byte) (chain.Block, bool) {
+	if n, ok := t.nodes[hash]; ok {
+		return n.block, true
+	}
+	return chain.Block{}, false
+}
+
+func (t *BlockTree) Tip() chain.Block {
+	return t.tip.block
+}
+
+func (t *BlockTree) add(b chain.Block, parent *treeNode) *treeNode {
+	n := &treeNode{
+		block:   b,
+		parent:  parent,
+		work:    new(big.Int).Add(parent.work, chain.BlockWork(b)),
+		arrival: t.added,
+	}
+	t.added++
+	parent.children = append(parent.children, n)
+	t.nodes[b.Hash] = n
+	return n
+}
+
+// looks up blocks on the branch ending at tip
+func (t *BlockTree) ancestorFunc(tip *treeNode) func(seqNum uint64) chain.Block {
+	return func(seqNum uint64) chain.Block {
+		n := tip
+		for n.block.SeqNum > seqNum && n.parent != nil {
+			n = n.parent
+		}
+		return n.block
+	}
+}
+
+// whether b fits as a child of parent: next SeqNum, the difficulty the
+// retarget rule asks for and a timestamp past the median time
+func (t *BlockTree) fitsParent(b chain.Block, parent *treeNode) bool {
+	ancestor := t.ancestorFunc(parent)
+	return b.SeqNum == parent.block.SeqNum+1 &&
+		b.Difficulty == t.params.NextDifficulty(parent.block, ancestor) &&
+		b.Timestamp > chain.MedianTimePast(parent.block, ancestor)
+}
+
+// park a block until its parent shows up. Returns false if it was dropped.
+func (t *BlockTree) addOrphan(b chain.Block) bool {
+	if t.nOrphan >= MAX_ORPHANS {
+		return false
+	}
+	for _, o := range t.orphans[b.ParentHash] {
+		if o.Hash == b.Hash {
+			return false
+		}
+	}
+	t.orphans[b.ParentHash] = append(t.orphans[b.ParentHash], b)
+	t.nOrphan++
+	return true
+}
+
+func (t *BlockTree) takeOrphans(parent [sha256.Size]byte) []chain.Block {
+	bs := t.orphans[parent]
+	delete(t.orphans, parent)
+	t.nOrphan -= len(bs)
+	return bs
+}
+
+// drop n and everything built on top of it
+func (t *BlockTree) remove(n *treeNode) {
+	if p := n.parent; p != nil {
+		for i, c := range p.children {
+			if c == n {
+				p.children = append(p.children[:i], p.children[i+1:]...)
+				break
+			}
+		}
+	}
+	stack := []*treeNode{n}
+	for len(stack) > 0 {
+		c := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		delete(t.nodes, c.block.Hash)
+		stack = append(stack, c.children...)
+	}
+}
+
+// the leaf with the most work; the current tip wins ties, and otherwise
+// the one we got first, so map order doesn't decide
+func (t *BlockTree) bestTip() *treeNode {
+	best := t.tip
+	for _, n := range t.nodes {
+		if len(n.children) != 0 {
+			continue
+		}
+		c := n.work.Cmp(best.work)
+		if c > 0 || (c == 0 && best != t.tip && n.arrival < best.arrival) {
+			best = n
+		}
+	}
+	return best
+}
+
+// the last block a and b have in common
+func forkPoint(a *treeNode, b *treeNode) *treeNode {
+	for a.block.SeqNum > b.block.SeqNum {
+		a = a.parent
+	}
+	for b.block.SeqNum > a.block.SeqNum {
+		b = b.parent
+	}
+	for a != b {
+		a = a.parent
+		b = b.parent
+	}
+	return a
+}
+
+// Precondition: mMu acquired
+// processBlock adds a block that passed the sanity check to the tree (along
+// with any orphans waiting on it) and moves the main chain to the branch
+// with the most work. Returns true if the tip changed.
+func (ns *NodeServer) processBlock(b chain.Block) bool {
+	if ns.isdead() || ns.tree.Has(b.Hash) {
+		return false
+	}
+	if !ns.tree.Has(b.ParentHash) {
+		if b.SeqNum > ns.tree.tip.block.SeqNum+1 {
+			// we are more than a block behind; catch up in bulk
+			ns.requestSync()
+		} else if ns.tree.addOrphan(b) {
+			go ns.fetchBlock(b.ParentHash)
+		}
+		return false
+	}
+
+	oldTip := ns.tree.tip
+	best := oldTip
+	pending := []chain.Block{b}
+	for len(pending) > 0 {
+		blk := pending[0]
+		pending = pending[1:]
+		if ns.tree.Has(blk.Hash) {
+			continue
+		}
+		parent := ns.tree.nodes[blk.ParentHash]
+		if !ns.tree.fitsParent(blk, parent) {
+			continue
+		}
+		n := ns.tree.add(blk, parent)
+		if n.work.Cmp(best.work) > 0 {
+			best = n
+		}
+		pending = append(pending, ns.tree.takeOrphans(blk.Hash)...)
+	}
+
+	for best.work.Cmp(ns.tree.tip.work) > 0 {
+		bad := ns.reorganize(best)
+		if bad == nil {
+			break
+		}
+		log.Printf("block %v on the heaviest branch is invalid, dropping it", bad.block.SeqNum)
+		ns.tree.remove(bad)
+		best = ns.tree.bestTip()
+	}
+	if ns.tree.tip != oldTip {
+		// pending transactions may clash with what the new blocks did
+		ns.mempool.Revalidate()
+		return true
+	}
+	return false
+}
+
+// Precondition: mMu acquired
+// reorganize moves the main chain from the current tip to target: unwind
+// back to where the branches fork, then validate and apply target's branch
+// block by block. If a block on the way turns out to be invalid it is
+// returned and the main chain is left ending at its parent.
+func (ns *NodeServer) reorganize(target *treeNode) *treeNode {
+	fork := forkPoint(ns.tree.tip, target)
+
+	// undo the old branch's changes to the directory, and give its
+	// transactions another chance once we are on the new branch
+	rebuild := false
+	var unwound []txn.Transaction
+	for n := ns.tree.tip; n != fork; n = n.parent {
+		if n.undo == nil {
+			rebuild = true
+		}
+		// oldest block first, so registrations go back before updates
+		unwound = append(append([]txn.Transaction(nil), n.block.Transactions...), unwound...)
+	}
+	defer ns.mempool.Reinject(unwound)
+	for n := ns.tree.tip; n != fork; n = n.parent {
+		if !rebuild {
+			chain.UndoBlock(ns.db, n.undo)
+		}
+		n.undo = nil
+	}
+	if rebuild {
+		// some of these were applied before we booted; start over from the
+		// newest snapshot below the fork instead
+		ns.db = chain.LoadDirectoryAt(ns.snaps, ns.chain, fork.block.SeqNum)
+	}
+	ns.tree.tip = fork
+
+	var path []*treeNode
+	for n := target; n != fork; n = n.parent {
+		path = append(path, n)
+	}
+	for i := len(path) - 1; i >= 0; i-- {
+		n := path[i]
+		b := n.block
+		if b.ValidateHash(chain.NewStoreView(ns.chain, ns.params), ns.clock.Now().Unix()) != nil || b.ValidateTxn(ns.db, ns.params, ns.caKey) != nil {
+			return n
+		}
+		undo := chain.ApplyBlock(ns.db, &b)
+		// the header has to commit to the directory we end up with
+		if ns.db.Root() != b.StateRoot {
+			chain.UndoBlock(ns.db, undo)
+			return n
+		}
+		if err := ns.chain.Put(b); err != nil {
+			log.Print(err)
+			chain.UndoBlock(ns.db, undo)
+			return n
+		}
+		n.undo = undo
+		ns.tree.tip = n
+		ns.mempool.BlockConnected(&b)
+		ns.maybeSnapshot(&b)
+	}
+	return nil
+}
+
+// ask our peers for a block we are missing and queue it up for processing
+func (ns *NodeServer) fetchBlock(hash [sha256.Size]byte) {
+	for _, peer := range ns.Peers() {
+		found, b := ns.RequestBlockByHash(peer, hash)
+		if found && b.Hash == hash && ns.blockSanityCheck(b) {
+			ns.qMu.Lock()
+			if ns.blkQueue.Count() < MAX_QUEUE {
+				ns.blkQueue.Push(b)
+			}
+			ns.qMu.Unlock()
+			return
+		}
+	}
+}
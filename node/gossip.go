@@ -0,0 +1,249 @@
+package node
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/tslilyai/SLYkey/chain"
+	"github.com/tslilyai/SLYkey/rpc"
+	"github.com/tslilyai/SLYkey/txn"
+)
+
+const (
+	// how long we remember having seen a block or transaction
+	SeenExpiry = 10 * time.Minute
+	// most entries the seen-cache holds before forgetting the oldest
+	MaxSeen = 1 << 16
+	// most items in one Announce or GetData
+	MaxInv = 1000
+)
+
+type seenEntry struct {
+	at  time.Time
+	txn *txn.Transaction // so we can hand it out to peers who ask
+}
+
+// seenCache remembers which blocks and transactions went by recently, so
+// we neither fetch nor announce anything twice. Both a size bound and an
+// expiry keep it from growing forever.
+type seenCache struct {
+	mu      sync.Mutex
+	entries map[rpc.InvItem]*seenEntry
+	order   []rpc.InvItem // oldest first
+	clock   Clock
+}
+
+func newSeenCache(clock Clock) *seenCache {
+	return &seenCache{entries: make(map[rpc.InvItem]*seenEntry), clock: clock}
+}
+
+// Precondition: mu acquired
+func (c *seenCache) expire() {
+	now := c.clock.Now()
+	for len(c.order) > 0 {
+		e, ok := c.entries[c.order[0]]
+		if ok && len(c.order) <= MaxSeen && now.Sub(e.at) < SeenExpiry {
+			break
+		}
+		delete(c.entries, c.order[0])
+		c.order = c.order[1:]
+	}
+}
+
+// add records item as seen, returning false if it already was
+func (c *seenCache) add(item rpc.InvItem, txn *txn.Transaction) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.expire()
+	if _, ok := c.entries[item]; ok {
+		return false
+	}
+	c.entries[item] = &seenEntry{at: c.clock.Now(), txn: txn}
+	c.order = append(c.order, item)
+	return true
+}
+
+func (c *seenCache) has(item rpc.InvItem) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.expire()
+	_, ok := c.entries[item]
+	return ok
+}
+
+func (c *seenCache) txn(hash [sha256.Size]byte) (txn.Transaction, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[rpc.InvItem{Type: rpc.InvTxn, Hash: hash}]
+	if !ok || e.txn == nil {
+		return txn.Transaction{}, false
+	}
+	return *e.txn, true
+}
+
+// queue a transaction made on this node to be mined and announced
+// Precondition: mMu acquired
+func (ns *NodeServer) submitTransaction(t txn.Transaction) error {
+	if err := ns.mempool.Add(t); err != nil {
+		return err
+	}
+	select {
+	case ns.localTxns <- t:
+	default:
+		// nobody is relaying; it still gets mined here
+	}
+	return nil
+}
+
+// RPC methods for gossip
+func (ns *NodeServer) SendAnnounce(remote string, items []rpc.InvItem) bool {
+	args := rpc.AnnounceArgs{From: ns.addr, Items: items}
+	reply := rpc.AnnounceReply{}
+	return ns.pool.Call(remote, "ns.Announce", args, &reply)
+}
+
+// Announce is a peer telling us about blocks and transactions it has; we go
+// and fetch the ones we haven't seen
+func (ns *NodeServer) Announce(args *rpc.AnnounceArgs, reply *rpc.AnnounceReply) error {
+	if len(args.Items) > MaxInv {
+		return fmt.Errorf(rpc.ErrRejected)
+	}
+	var want []rpc.InvItem
+	for _, item := range args.Items {
+		if ns.seen.has(item) {
+			continue
+		}
+		if item.Type == rpc.InvBlock {
+			ns.mMu.Lock()
+			known := ns.tree.Has(item.Hash)
+			ns.mMu.Unlock()
+			if known {
+				continue
+			}
+		}
+		want = append(want, item)
+	}
+	if len(want) > 0 {
+		// don't hold up the announcer while we check on it and fetch
+		go func() {
+			if ns.checkPeer(args.From) {
+				ns.fetchInv(args.From, want)
+			}
+		}()
+	}
+	reply.Status = rpc.ErrOK
+	return nil
+}
+
+func (ns *NodeServer) RequestData(remote string, items []rpc.InvItem) (bool, []chain.Block, []txn.Transaction) {
+	args := rpc.GetDataArgs{Items: items}
+	reply := rpc.GetDataReply{}
+	ok := ns.pool.Call(remote, "ns.GetData", args, &reply)
+	if ok && reply.Status == rpc.ErrFound {
+		return true, reply.Blocks, reply.Txns
+	}
+	return false, nil, nil
+}
+
+func (ns *NodeServer) GetData(args *rpc.GetDataArgs, reply *rpc.GetDataReply) error {
+	if len(args.Items) > MaxInv {
+		return fmt.Errorf(rpc.ErrRejected)
+	}
+	for _, item := range args.Items {
+		switch item.Type {
+		case rpc.InvBlock:
+			ns.mMu.Lock()
+			b, ok := ns.tree.Get(item.Hash)
+			ns.mMu.Unlock()
+			if ok {
+				reply.Blocks = append(reply.Blocks, b)
+			}
+		case rpc.InvTxn:
+			if t, ok := ns.seen.txn(item.Hash); ok {
+				reply.Txns = append(reply.Txns, t)
+			}
+		}
+	}
+	if len(reply.Blocks) == 0 && len(reply.Txns) == 0 {
+		return fmt.Errorf(rpc.ErrNotFound)
+	}
+	reply.Status = rpc.ErrFound
+	return nil
+}
+
+// End of RPC methods for gossip
+
+// announce items to every peer but except (who told us about them)
+func (ns *NodeServer) announce(items []rpc.InvItem, except string) {
+	for _, peer := range ns.Peers() {
+		if peer != except {
+			go ns.SendAnnounce(peer, items)
+		}
+	}
+}
+
+// fetch the items from the peer that announced them, and pass on the ones
+// that check out
+func (ns *NodeServer) fetchInv(from string, items []rpc.InvItem) {
+	ok, blocks, txns := ns.RequestData(from, items)
+	if !ok {
+		return
+	}
+	asked := make(map[rpc.InvItem]bool)
+	for _, item := range items {
+		asked[item] = true
+	}
+
+	var relay []rpc.InvItem
+	for _, b := range blocks {
+		item := rpc.InvItem{Type: rpc.InvBlock, Hash: b.Hash}
+		if !asked[item] || !ns.blockSanityCheck(b) {
+			continue
+		}
+		// someone else may have got it to us in the meantime
+		if !ns.seen.add(item, nil) {
+			continue
+		}
+		ns.qMu.Lock()
+		if ns.blkQueue.Count() < MAX_QUEUE {
+			ns.blkQueue.Push(b)
+		}
+		ns.qMu.Unlock()
+		relay = append(relay, item)
+	}
+	for i := range txns {
+		t := txns[i]
+		item := rpc.InvItem{Type: rpc.InvTxn, Hash: t.Hash()}
+		if !asked[item] || !ns.seen.add(item, &t) {
+			continue
+		}
+		// only pass on what we would mine ourselves
+		ns.mMu.Lock()
+		err := ns.mempool.Add(t)
+		ns.mMu.Unlock()
+		if err != nil {
+			continue
+		}
+		relay = append(relay, item)
+	}
+	if len(relay) > 0 {
+		ns.announce(relay, from)
+	}
+}
+
+// RelayTransactions runs in the background, announcing the transactions
+// submitted to this node
+func (ns *NodeServer) RelayTransactions() {
+	for !ns.isdead() {
+		select {
+		case t := <-ns.localTxns:
+			item := rpc.InvItem{Type: rpc.InvTxn, Hash: t.Hash()}
+			if ns.seen.add(item, &t) {
+				ns.announce([]rpc.InvItem{item}, "")
+			}
+		case <-ns.clock.After(time.Second):
+		}
+	}
+}
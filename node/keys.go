@@ -0,0 +1,164 @@
+package node
+
+import (
+	"crypto/rsa"
+	"fmt"
+
+	"github.com/tslilyai/SLYkey/client"
+	"github.com/tslilyai/SLYkey/txn"
+)
+
+var ErrNotRegistered = fmt.Errorf("not registered")
+
+// RevokedError is what GetPublicKey says about a revoked email: its key was
+// revoked in the block at SeqNum
+type RevokedError struct {
+	Email  string
+	SeqNum uint64
+}
+
+func (e *RevokedError) Error() string {
+	return fmt.Sprintf("%v was revoked in block %v", e.Email, e.SeqNum)
+}
+
+// Precondition: mMu acquired
+// email's identity as of the block at seqNum (see txn.Settle), nil if it
+// isn't registered
+func (ns *NodeServer) identity(email string, seqNum uint64) *txn.Identity {
+	return txn.Settle(ns.db.Lookup(email), seqNum)
+}
+
+// email's current key; ErrNotRegistered if it has none, or a *RevokedError
+func (ns *NodeServer) GetPublicKey(email string) (rsa.PublicKey, error) {
+	ns.mMu.Lock()
+	defer ns.mMu.Unlock()
+	id := ns.identity(email, ns.tree.Tip().SeqNum)
+	if id == nil {
+		return rsa.PublicKey{}, ErrNotRegistered
+	}
+	if id.Revoked {
+		return rsa.PublicKey{}, &RevokedError{Email: email, SeqNum: id.RevokedAt}
+	}
+	return id.PublicKey, nil
+}
+
+// email's keys that have every purpose in purpose (all of them for 0),
+// primary key first; errors like GetPublicKey
+func (ns *NodeServer) GetKeys(email string, purpose txn.Purpose) ([]txn.Key, error) {
+	ns.mMu.Lock()
+	defer ns.mMu.Unlock()
+	id := ns.identity(email, ns.tree.Tip().SeqNum)
+	if id == nil {
+		return nil, ErrNotRegistered
+	}
+	if id.Revoked {
+		return nil, &RevokedError{Email: email, SeqNum: id.RevokedAt}
+	}
+	return txn.WithPurpose(id.KeySet(), purpose), nil
+}
+
+// GetIdentity is email's key along with its sequence number, which the
+// next update has to be one past, and its recovery state
+func (ns *NodeServer) GetIdentity(email string) (txn.Identity, bool) {
+	ns.mMu.Lock()
+	defer ns.mMu.Unlock()
+	id := ns.identity(email, ns.tree.Tip().SeqNum)
+	if id == nil {
+		return txn.Identity{}, false
+	}
+	return *id, true
+}
+
+// Returns error on failure, nil on success
+// Registers a public key transaction, signed by the CA
+func (ns *NodeServer) RegisterPublicKey(key rsa.PublicKey, email string) error {
+	ns.mMu.Lock()
+	_, ok := ns.db.Get(email)
+	ns.mMu.Unlock()
+	// value already in map, don't reregister
+	if ok {
+		return fmt.Errorf("You have already registered for a public key")
+	}
+	if ns.caURL == "" {
+		return fmt.Errorf("This node has no CA to register through")
+	}
+	// the CA picks the nonce the registration is bound to
+	reg, err := client.RequestRegistration(ns.caURL, email, &key, nil, 0)
+	if err != nil {
+		return err
+	}
+	trans := txn.Transaction{
+		Type:      txn.Register,
+		Email:     email,
+		PublicKey: key,
+		Nonce:     reg.Nonce,
+		Signature: reg.Signature,
+	}
+	// queue it up for mining, and tell the network
+	return ns.SubmitTransaction(trans)
+}
+
+// Returns error on failure, nil on success
+// Updates a public key, signed by the user
+// sig is by the current key, over the txn.SigHash of the identity's next
+// update (see client.SignUpdate)
+func (ns *NodeServer) UpdatePublicKey(key rsa.PublicKey, sig []byte, email string) error {
+	return ns.submitChange(txn.Transaction{Type: txn.Update, Email: email, PublicKey: key, Signature: sig})
+}
+
+// Returns error on failure, nil on success
+// Revokes email's key for good, signed by the user
+// sig is by the current (or recovery) key, over the txn.SigHash of the
+// identity's next transaction as a revocation (see client.SignRevoke)
+func (ns *NodeServer) RevokePublicKey(sig []byte, email string) error {
+	return ns.submitChange(txn.Transaction{Type: txn.Revoke, Email: email, Signature: sig})
+}
+
+// Returns error on failure, nil on success
+// Starts moving email to key, signed by its recovery key; key takes over
+// once the identity's recovery delay is up, unless the current key cancels
+// sig is over the txn.SigHash of the identity's next transaction as a
+// recovery (see client.SignRecover)
+func (ns *NodeServer) RecoverPublicKey(key rsa.PublicKey, sig []byte, email string) error {
+	return ns.submitChange(txn.Transaction{Type: txn.Recover, Email: email, PublicKey: key, Signature: sig})
+}
+
+// Returns error on failure, nil on success
+// Cancels a pending recovery of email, signed by the current key
+// sig is over the txn.SigHash of the identity's next transaction as a
+// cancellation (see client.SignCancelRecovery)
+func (ns *NodeServer) CancelRecovery(sig []byte, email string) error {
+	return ns.submitChange(txn.Transaction{Type: txn.CancelRecovery, Email: email, Signature: sig})
+}
+
+// Returns error on failure, nil on success
+// Adds key to email's key set as label, for purpose, signed by the primary
+// key or one in the set with txn.PurposeManage
+// sig is over the txn.SigHash of the identity's next transaction as a key
+// addition (see client.SignAddKey)
+func (ns *NodeServer) AddKey(key rsa.PublicKey, label string, purpose txn.Purpose, sig []byte, email string) error {
+	return ns.submitChange(txn.Transaction{Type: txn.AddKey, Email: email, PublicKey: key, Label: label, Purpose: purpose, Signature: sig})
+}
+
+// Returns error on failure, nil on success
+// Removes the key labelled label from email's key set, signed like AddKey
+// (see client.SignRemoveKey)
+func (ns *NodeServer) RemoveKey(label string, sig []byte, email string) error {
+	return ns.submitChange(txn.Transaction{Type: txn.RemoveKey, Email: email, Label: label, Signature: sig})
+}
+
+// the sequence number and previous key of a change to an identity come from
+// the identity as the next block will see it; whether the signature is good
+// for them is checked on the way into the mempool
+func (ns *NodeServer) submitChange(t txn.Transaction) error {
+	ns.mMu.Lock()
+	defer ns.mMu.Unlock()
+	id := ns.identity(t.Email, ns.tree.Tip().SeqNum+1)
+	if id == nil {
+		return fmt.Errorf("You have never registered for a public key")
+	}
+	t.Sequence = id.Sequence + 1
+	t.PrevKeyHash = txn.KeyHash(&id.PublicKey)
+	// queue it up for mining, and tell the network
+	return ns.submitTransaction(t)
+}
@@ -0,0 +1,234 @@
+package node
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/tslilyai/SLYkey/chain"
+	"github.com/tslilyai/SLYkey/txn"
+)
+
+const (
+	// most transactions the miner puts in one block
+	MaxBlockTxns = 1000
+	// most transactions waiting in the pool
+	MaxMempool = 10000
+	// a transaction nobody has mined in this long is dropped
+	MempoolExpiry = time.Hour
+)
+
+var (
+	ErrDuplicateTxn   = fmt.Errorf("transaction is already pending")
+	ErrConflictingTxn = fmt.Errorf("another transaction for this email is already pending")
+	ErrMempoolFull    = fmt.Errorf("too many pending transactions")
+)
+
+type poolEntry struct {
+	txn   txn.Transaction
+	added time.Time
+}
+
+// Mempool holds valid transactions that aren't in a block on our chain yet.
+// Each one is checked against the node's directory on the way in, and there is at most
+// one per email so they never depend on (or contradict) each other and any
+// subset of them makes a valid block. A reorganisation can hand back
+// several for one email (a registration and its update, say): the later
+// ones are held aside, by sequence number, and get their turn once the one
+// before them is mined.
+type Mempool struct {
+	mu       sync.Mutex
+	txns     map[[sha256.Size]byte]*poolEntry
+	byEmail  map[string][sha256.Size]byte
+	held     map[string][]*poolEntry // oldest sequence number first
+	nheld    int
+	validate func(t *txn.Transaction) error
+	clock    Clock
+}
+
+// NewMempool makes a pool that lets in transactions validate accepts, and
+// expires them by clock
+func NewMempool(validate func(t *txn.Transaction) error, clock Clock) *Mempool {
+	return &Mempool{
+		txns:     make(map[[sha256.Size]byte]*poolEntry),
+		byEmail:  make(map[string][sha256.Size]byte),
+		held:     make(map[string][]*poolEntry),
+		validate: validate,
+		clock:    clock,
+	}
+}
+
+// Add validates t and adds it to the pool. The caller must keep whatever
+// validate checks against from changing underneath (nodes hold mMu).
+func (mp *Mempool) Add(t txn.Transaction) error {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	return mp.add(t, mp.clock.Now())
+}
+
+// Precondition: mu acquired
+func (mp *Mempool) add(t txn.Transaction, added time.Time) error {
+	hash := t.Hash()
+	if _, ok := mp.txns[hash]; ok {
+		return ErrDuplicateTxn
+	}
+	if _, ok := mp.byEmail[t.Email]; ok {
+		return ErrConflictingTxn
+	}
+	if len(mp.txns)+mp.nheld >= MaxMempool {
+		return ErrMempoolFull
+	}
+	if err := mp.validate(&t); err != nil {
+		return err
+	}
+	mp.txns[hash] = &poolEntry{txn: t, added: added}
+	mp.byEmail[t.Email] = hash
+	return nil
+}
+
+// Precondition: mu acquired
+func (mp *Mempool) remove(hash [sha256.Size]byte) {
+	if e, ok := mp.txns[hash]; ok {
+		delete(mp.byEmail, e.txn.Email)
+		delete(mp.txns, hash)
+	}
+}
+
+func (mp *Mempool) Len() int {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	return len(mp.txns)
+}
+
+func (mp *Mempool) Get(hash [sha256.Size]byte) (txn.Transaction, bool) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	e, ok := mp.txns[hash]
+	if !ok {
+		return txn.Transaction{}, false
+	}
+	return e.txn, true
+}
+
+// Template returns up to max pending transactions, oldest first, for the
+// miner to put in a block. They stay in the pool until a block with them
+// makes it onto our chain.
+func (mp *Mempool) Template(max int) []txn.Transaction {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	mp.expire()
+	entries := make([]*poolEntry, 0, len(mp.txns))
+	for _, e := range mp.txns {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].added.Before(entries[j].added)
+	})
+	var txns []txn.Transaction
+	for i := 0; i < len(entries) && i < max; i++ {
+		txns = append(txns, entries[i].txn)
+	}
+	return txns
+}
+
+// Precondition: mu acquired
+// set t aside until the pending transaction for its email is mined
+func (mp *Mempool) hold(t txn.Transaction, added time.Time) {
+	if len(mp.txns)+mp.nheld >= MaxMempool {
+		return
+	}
+	queue := mp.held[t.Email]
+	i := sort.Search(len(queue), func(i int) bool { return queue[i].txn.Sequence > t.Sequence })
+	queue = append(queue, nil)
+	copy(queue[i+1:], queue[i:])
+	queue[i] = &poolEntry{txn: t, added: added}
+	mp.held[t.Email] = queue
+	mp.nheld++
+}
+
+// Precondition: mu acquired
+// give held transactions whose email has nothing pending any more their
+// turn; the ones that don't validate now never will, and are dropped
+func (mp *Mempool) promote() {
+	for email, queue := range mp.held {
+		for len(queue) > 0 {
+			if _, ok := mp.byEmail[email]; ok {
+				break
+			}
+			e := queue[0]
+			err := mp.add(e.txn, e.added)
+			if err == ErrMempoolFull {
+				break
+			}
+			queue = queue[1:]
+			mp.nheld--
+		}
+		if len(queue) == 0 {
+			delete(mp.held, email)
+		} else {
+			mp.held[email] = queue
+		}
+	}
+}
+
+// Precondition: mu acquired
+func (mp *Mempool) expire() {
+	for hash, e := range mp.txns {
+		if mp.clock.Now().Sub(e.added) > MempoolExpiry {
+			mp.remove(hash)
+		}
+	}
+	for email, queue := range mp.held {
+		var kept []*poolEntry
+		for _, e := range queue {
+			if mp.clock.Now().Sub(e.added) <= MempoolExpiry {
+				kept = append(kept, e)
+			}
+		}
+		mp.nheld -= len(queue) - len(kept)
+		if len(kept) == 0 {
+			delete(mp.held, email)
+		} else {
+			mp.held[email] = kept
+		}
+	}
+}
+
+// BlockConnected drops the transactions b put on our chain, which may let
+// held ones in. Call it once the directory has b applied.
+func (mp *Mempool) BlockConnected(b *chain.Block) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	for i := range b.Transactions {
+		mp.remove(b.Transactions[i].Hash())
+	}
+	mp.promote()
+}
+
+// Reinject puts back transactions from blocks a reorganisation took off our
+// chain, oldest first; the ones the new chain made invalid (or already has)
+// don't get in, and ones behind another for the same email are held
+func (mp *Mempool) Reinject(txns []txn.Transaction) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	for _, t := range txns {
+		if mp.add(t, mp.clock.Now()) == ErrConflictingTxn {
+			mp.hold(t, mp.clock.Now())
+		}
+	}
+}
+
+// Revalidate drops whatever validate no longer accepts, after the directory
+// changed
+func (mp *Mempool) Revalidate() {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	for hash, e := range mp.txns {
+		if mp.validate(&e.txn) != nil {
+			mp.remove(hash)
+		}
+	}
+	mp.promote()
+}
@@ -0,0 +1,563 @@
+package node
+
+import (
+	"crypto/rsa"
+	"crypto/sha256"
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/tslilyai/SLYkey/chain"
+	"github.com/tslilyai/SLYkey/rpc"
+	"github.com/tslilyai/SLYkey/txn"
+)
+
+const (
+	MAX_PEERS = 64
+	// most headers we hand out per request
+	MAX_HEADERS = 2000
+	// how long the worker and the processing thread nap when there is
+	// nothing to do
+	IdleWait = 10 * time.Millisecond
+	// how long the worker waits for a block it mined to be processed
+	MinedWait = time.Second
+)
+
+type NodeServer struct {
+	qMu           sync.Mutex // mutex for BlockQueue
+	mMu           sync.Mutex // mutex for chain, db and tree
+	dead          int32
+	rpcListener   net.Listener
+	addr          string
+	pMu           sync.Mutex               // mutex for peers and checking
+	peers         []string                 // the peers we are connected to
+	checking      map[string]chan struct{} // addresses checkPeer is asking, closed when done
+	peerDB        *PeerDB                  // every peer we know of
+	seeds         []string
+	targetPeers   int
+	workerChannel chan chain.Block
+	mining        bool  // whether WorkOnBlock runs, reading workerChannel
+	miner         Miner // does WorkOnBlock's proof of work
+	blkQueue      *BlockQueue
+	params        *chain.ChainParams   // the rules of our network
+	clock         Clock                // where timestamps and timers come from
+	rand          *rand.Rand           // for ManagePeers only
+	chain         chain.BlockStore     // our main chain
+	db            *chain.Directory     // the directory as of our tip
+	caKey         *rsa.PublicKey       // what registrations must be signed by
+	caURL         string               // where RegisterPublicKey asks the CA
+	snaps         *chain.SnapshotStore // nil if we don't keep snapshots
+	tree          *BlockTree           // every block we know of, protected by mMu
+	mempool       *Mempool             // transactions waiting to be mined
+	// transactions handed to this node directly (rather than heard about
+	// from a peer), waiting for the node to announce them
+	localTxns  chan txn.Transaction
+	transport  rpc.Transport
+	pool       *rpc.ConnPool // connections to peers
+	sMu        sync.Mutex    // mutex for syncStatus
+	syncStatus SyncStatus
+	syncNeeded chan struct{}
+	seen       *seenCache   // blocks and transactions gossiped recently
+	apiServer  *http.Server // nil if we don't serve the client API
+}
+
+// NodeConfig is everything NewNodeServer needs to know
+type NodeConfig struct {
+	Addr string // local address, also what we tell other nodes
+	// the network's rules; MainNetParams if nil
+	Params *chain.ChainParams
+	// where the node gets the time; the wall clock if nil
+	Clock Clock
+	// where the node's random choices come from; seeded from the wall clock
+	// if nil. Only the node's own goroutines use it.
+	Rand *rand.Rand
+	// validate and relay, but don't mine
+	NoMining bool
+	// how mined blocks get their proof of work; SolveBlock if nil
+	Miner Miner
+	// addresses to find the network through; we learn the rest from them
+	Seeds []string
+	// where known peers are kept; in memory if nil
+	PeerDB *PeerDB
+	// how many peers to stay connected to, at most MAX_PEERS
+	TargetPeers int
+	// where the block chain lives (see OpenFileBlockStore); in memory if nil.
+	// It has to start at Params' genesis block.
+	Store chain.BlockStore
+	// where directory snapshots go, may be nil
+	Snapshots *chain.SnapshotStore
+	// how we talk to peers; unix domain sockets if nil
+	Transport rpc.Transport
+	// host:port to serve the client API on; none if empty
+	APIAddr string
+	// the CA whose signature registrations need; with none, nothing can
+	// register through this node
+	CAKey *rsa.PublicKey
+	// where RegisterPublicKey gets registrations signed; with none it can't
+	CAURL string
+}
+
+func assert(condition bool, func_name string) {
+	if !condition {
+		log.Fatalf("Assertion failure in function %v", func_name)
+	}
+}
+
+// NewNodeServer starts a node, or returns why it couldn't listen; nothing
+// is left running then
+func NewNodeServer(cfg NodeConfig) (*NodeServer, error) {
+	if cfg.Params == nil {
+		params := chain.MainNetParams
+		cfg.Params = &params
+	}
+	if cfg.Clock == nil {
+		cfg.Clock = RealClock{}
+	}
+	if cfg.Rand == nil {
+		cfg.Rand = rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+	if cfg.Miner == nil {
+		cfg.Miner = SolveBlock
+	}
+	if cfg.Store == nil {
+		cfg.Store = chain.NewMemBlockStore(cfg.Params.Genesis())
+	}
+	if cfg.Transport == nil {
+		cfg.Transport = rpc.UnixTransport{}
+	}
+	if cfg.PeerDB == nil {
+		cfg.PeerDB, _ = OpenPeerDB("")
+	}
+	if cfg.TargetPeers <= 0 || cfg.TargetPeers > MAX_PEERS {
+		cfg.TargetPeers = MAX_PEERS
+	}
+	cfg.PeerDB.setClock(cfg.Clock)
+	cfg.PeerDB.Add(cfg.Seeds...)
+	ns := &NodeServer{
+		// initialize fields here
+		dead:          0,
+		addr:          cfg.Addr,
+		peerDB:        cfg.PeerDB,
+		seeds:         cfg.Seeds,
+		targetPeers:   cfg.TargetPeers,
+		checking:      make(map[string]chan struct{}),
+		workerChannel: make(chan chain.Block, 16),
+		mining:        !cfg.NoMining,
+		miner:         cfg.Miner,
+		blkQueue:      NewBlockQueue(1),
+		params:        cfg.Params,
+		clock:         cfg.Clock,
+		rand:          cfg.Rand,
+		chain:         cfg.Store,
+		db:            chain.LoadDirectory(cfg.Snapshots, cfg.Store),
+		caKey:         cfg.CAKey,
+		caURL:         cfg.CAURL,
+		snaps:         cfg.Snapshots,
+		tree:          NewBlockTree(cfg.Store, cfg.Params),
+		localTxns:     make(chan txn.Transaction, 256),
+		transport:     cfg.Transport,
+		pool:          rpc.NewConnPool(cfg.Transport, cfg.Clock),
+		syncNeeded:    make(chan struct{}, 1),
+		seen:          newSeenCache(cfg.Clock),
+	}
+	ns.mempool = NewMempool(ns.validateTxn, cfg.Clock)
+	if err := ns.StartRPCServer(cfg.Addr); err != nil {
+		return nil, err
+	}
+	if cfg.APIAddr != "" {
+		if err := ns.StartAPIServer(cfg.APIAddr); err != nil {
+			ns.Shutdown()
+			return nil, fmt.Errorf("client API listen error: %v", err)
+		}
+	}
+	go ns.ManagePeers()
+	go ns.SyncBlocks()
+	go ns.ProcessBlock()
+	go ns.RelayTransactions()
+	if ns.mining {
+		// pick up from wherever the stored chain left off
+		go ns.WorkOnBlock(ns.chain.Tip())
+	}
+	return ns, nil
+}
+
+func (ns *NodeServer) isdead() bool {
+	return atomic.LoadInt32(&ns.dead) != 0
+}
+
+// Shutdown stops the node. Once it returns the node won't touch its block
+// store again, so the caller can close it.
+func (ns *NodeServer) Shutdown() {
+	atomic.StoreInt32(&ns.dead, 1)
+	if ns.rpcListener != nil {
+		ns.rpcListener.Close()
+	}
+	if ns.apiServer != nil {
+		ns.apiServer.Close()
+	}
+	ns.pool.Close()
+	// wait out any block being processed; processBlock does nothing after
+	// this
+	ns.mMu.Lock()
+	ns.mMu.Unlock()
+}
+
+// RPC methods here!!
+func (ns *NodeServer) RequestBlock(remote string, seqNum uint64) (bool, chain.Block) {
+	args := rpc.RequestBlockArgs{}
+	reply := rpc.RequestBlockReply{}
+	args.SeqNum = seqNum
+	ok := ns.pool.Call(remote, "ns.RemoteBlockLookup", args, &reply)
+	if ok && reply.Status == rpc.ErrFound {
+		return true, reply.Block
+	} else {
+		return false, chain.Block{}
+	}
+}
+
+func (ns *NodeServer) RequestBlockByHash(remote string, hash [sha256.Size]byte) (bool, chain.Block) {
+	args := rpc.RequestBlockArgs{}
+	reply := rpc.RequestBlockReply{}
+	args.ByHash = true
+	args.Hash = hash
+	ok := ns.pool.Call(remote, "ns.RemoteBlockLookup", args, &reply)
+	if ok && reply.Status == rpc.ErrFound {
+		return true, reply.Block
+	} else {
+		return false, chain.Block{}
+	}
+}
+
+func (ns *NodeServer) RemoteBlockLookup(args *rpc.RequestBlockArgs, reply *rpc.RequestBlockReply) error {
+	ns.mMu.Lock()
+	defer ns.mMu.Unlock()
+
+	if args.ByHash {
+		// side branches too, so peers can follow a fork we know about
+		if block, ok := ns.tree.Get(args.Hash); ok {
+			reply.Block = block
+			reply.Status = rpc.ErrFound
+			return nil
+		}
+		return fmt.Errorf(rpc.ErrNotFound)
+	}
+	if block, ok := ns.chain.Get(args.SeqNum); ok {
+		reply.Block = block
+		reply.Status = rpc.ErrFound
+		return nil
+	}
+	return fmt.Errorf(rpc.ErrNotFound)
+}
+
+func (ns *NodeServer) RemoteHeaderLookup(args *rpc.RequestHeadersArgs, reply *rpc.RequestHeadersReply) error {
+	ns.mMu.Lock()
+	defer ns.mMu.Unlock()
+
+	from := args.From
+	if len(args.Locator) > 0 {
+		from = 1
+		for _, hash := range args.Locator {
+			if b, ok := ns.chain.GetByHash(hash); ok {
+				from = b.SeqNum + 1
+				break
+			}
+		}
+	}
+	count := args.Count
+	if count > MAX_HEADERS {
+		count = MAX_HEADERS
+	}
+	reply.From = from
+	for seq := from; seq < from+count; seq++ {
+		b, ok := ns.chain.Get(seq)
+		if !ok {
+			break
+		}
+		reply.Headers = append(reply.Headers, b.BlockHeader)
+	}
+	if len(reply.Headers) == 0 {
+		return fmt.Errorf(rpc.ErrNotFound)
+	}
+	reply.Status = rpc.ErrFound
+	return nil
+}
+
+// RequestTxnProof asks remote for the transaction for email in block seqNum
+// and checks the proof against the hash of our own copy of that block
+func (ns *NodeServer) RequestTxnProof(remote string, email string, seqNum uint64) (bool, txn.Transaction) {
+	args := rpc.RequestTxnProofArgs{SeqNum: seqNum, Email: email}
+	reply := rpc.RequestTxnProofReply{}
+	ok := ns.pool.Call(remote, "ns.RemoteTxnProof", args, &reply)
+	if !ok || reply.Status != rpc.ErrFound {
+		return false, txn.Transaction{}
+	}
+	b, found := ns.chain.Get(seqNum)
+	if !found || reply.Proof.Transaction.Email != email || reply.Proof.Verify(b.Hash) != nil {
+		return false, txn.Transaction{}
+	}
+	return true, reply.Proof.Transaction
+}
+
+func (ns *NodeServer) RemoteTxnProof(args *rpc.RequestTxnProofArgs, reply *rpc.RequestTxnProofReply) error {
+	proof, err := ns.ProveTransaction(args.Email, args.SeqNum)
+	if err != nil {
+		return fmt.Errorf(rpc.ErrNotFound)
+	}
+	reply.Proof = proof
+	reply.Status = rpc.ErrFound
+	return nil
+}
+
+// RequestKeyProof asks remote what key email has, and only believes the
+// answer if it is proven against the header of a block in our own chain
+func (ns *NodeServer) RequestKeyProof(remote string, email string) (bool, chain.KeyProof) {
+	args := rpc.RequestKeyProofArgs{Email: email}
+	reply := rpc.RequestKeyProofReply{}
+	ok := ns.pool.Call(remote, "ns.RemoteKeyLookup", args, &reply)
+	if !ok || reply.Status != rpc.ErrFound || reply.Proof.Lookup.Email != email {
+		return false, chain.KeyProof{}
+	}
+	b, found := ns.chain.Get(reply.Proof.SeqNum)
+	if !found || reply.Proof.Verify(b.Hash) != nil {
+		return false, chain.KeyProof{}
+	}
+	return true, reply.Proof
+}
+
+func (ns *NodeServer) RemoteKeyLookup(args *rpc.RequestKeyProofArgs, reply *rpc.RequestKeyProofReply) error {
+	reply.Proof = ns.LookupKey(args.Email)
+	reply.Status = rpc.ErrFound
+	return nil
+}
+
+// End of RPC methods
+
+// LookupKey returns email's key (or its absence) as of our tip, proven
+// against the tip's header
+func (ns *NodeServer) LookupKey(email string) chain.KeyProof {
+	ns.mMu.Lock()
+	defer ns.mMu.Unlock()
+
+	tip := ns.tree.Tip()
+	return chain.KeyProof{
+		Header: tip.BlockHeader,
+		SeqNum: tip.SeqNum,
+		Lookup: ns.db.Prove(email),
+	}
+}
+
+// Tip returns the last block of our main chain
+func (ns *NodeServer) Tip() chain.Block {
+	ns.mMu.Lock()
+	defer ns.mMu.Unlock()
+	return ns.tree.Tip()
+}
+
+// GetBlock returns block seqNum of our main chain
+func (ns *NodeServer) GetBlock(seqNum uint64) (chain.Block, bool) {
+	ns.mMu.Lock()
+	defer ns.mMu.Unlock()
+	return ns.chain.Get(seqNum)
+}
+
+// DirectoryRoot returns the root of our directory as it is, which had better
+// be the StateRoot of our tip
+func (ns *NodeServer) DirectoryRoot() [sha256.Size]byte {
+	ns.mMu.Lock()
+	defer ns.mMu.Unlock()
+	return ns.db.Root()
+}
+
+// SubmitTransaction validates t against our tip and queues it up for mining
+// and gossip
+func (ns *NodeServer) SubmitTransaction(t txn.Transaction) error {
+	ns.mMu.Lock()
+	defer ns.mMu.Unlock()
+	return ns.submitTransaction(t)
+}
+
+// tell the worker about a new tip. Could block, so not with mMu held.
+func (ns *NodeServer) newTip(tip chain.Block) {
+	if ns.mining {
+		ns.workerChannel <- tip
+	}
+}
+
+// Precondition: mMu acquired
+// check t against our tip, for the mempool: as if it went in the next block
+func (ns *NodeServer) validateTxn(t *txn.Transaction) error {
+	last := txn.Settle(ns.db.Lookup(t.Email), ns.tree.Tip().SeqNum+1)
+	return txn.Validate(t, last, ns.caKey, ns.params.ChainID)
+}
+
+// listens with the node's transport (unix domain sockets unless configured)
+// ** Call this function upon NodeServer initialization **
+func (ns *NodeServer) StartRPCServer(addr string) error {
+	l, err := ns.transport.Listen(addr)
+	if err != nil {
+		return fmt.Errorf("listen error: %v", err)
+	}
+	ns.rpcListener = l
+	if err := rpc.Serve(l, ns, ns.isdead); err != nil {
+		l.Close()
+		return fmt.Errorf("rpc register error: %v", err)
+	}
+	return nil
+}
+
+// ProveTransaction returns the transaction for email in block seqNum of our
+// chain along with a proof that checks out against just the block hash
+func (ns *NodeServer) ProveTransaction(email string, seqNum uint64) (chain.TxnProof, error) {
+	ns.mMu.Lock()
+	defer ns.mMu.Unlock()
+
+	b, ok := ns.chain.Get(seqNum)
+	if !ok {
+		return chain.TxnProof{}, fmt.Errorf("no block %v", seqNum)
+	}
+	return chain.ProveTransaction(&b, email)
+}
+
+// Precondition: mMu acquired
+// snapshot the directory every SnapshotInterval blocks; b is the block it
+// was just brought up to
+func (ns *NodeServer) maybeSnapshot(b *chain.Block) {
+	if ns.snaps != nil && b.SeqNum%chain.SnapshotInterval == 0 {
+		if err := ns.snaps.Write(chain.NewSnapshot(*b, ns.db)); err != nil {
+			log.Printf("snapshot at %v failed: %v", b.SeqNum, err)
+		}
+	}
+}
+
+// Yihe's processing thread:
+// checks the queue for incomings and notify worker thread
+// if necessary
+func (ns *NodeServer) ProcessBlock() error {
+	for !ns.isdead() {
+		ns.mMu.Lock()
+		ns.qMu.Lock()
+		if ns.blkQueue.Count() > 0 {
+			b := ns.blkQueue.Pop()
+			ns.qMu.Unlock()
+			if ns.blockSanityCheck(b) == false {
+				// skip garbage blocks
+				ns.mMu.Unlock()
+				continue
+			}
+			if ns.processBlock(b) {
+				tip := ns.tree.Tip()
+				ns.mMu.Unlock()
+				// signal worker of the new tip; could block so unlock
+				ns.newTip(tip)
+			} else {
+				ns.mMu.Unlock()
+			}
+		} else {
+			ns.qMu.Unlock()
+			ns.mMu.Unlock()
+			// nothing to do; don't hog mMu
+			<-ns.clock.After(IdleWait)
+		}
+	}
+	return nil
+}
+
+// making sure the block isn't random garbage...
+// (whether its difficulty and timestamp fit its parent is checked once we
+// know the parent)
+func (ns *NodeServer) blockSanityCheck(b chain.Block) bool {
+	return b.Version == chain.BlockVersion && b.GetHash() == b.Hash &&
+		b.Difficulty >= ns.params.MinDifficulty && chain.HashMeetsDifficulty(b.Hash, b.Difficulty) &&
+		b.Timestamp <= ns.clock.Now().Unix()+chain.MaxFutureDrift &&
+		b.MerkleRoot == chain.MerkleRoot(b.Transactions)
+}
+
+func (ns *NodeServer) BlockCompare(b1 chain.Block, b2 chain.Block) bool {
+	assert(ns.blockSanityCheck(b1) && ns.blockSanityCheck(b2), "ns.BlockCompare")
+	return b1.Hash == b2.Hash
+}
+
+// fill in what b needs to go on top of parent: SeqNum, difficulty, the
+// directory root after its transactions, and a timestamp of now (unless
+// that isn't past the median time of the blocks before it)
+func (ns *NodeServer) prepareBlock(b *chain.Block, parent chain.Block) {
+	ns.mMu.Lock()
+	defer ns.mMu.Unlock()
+	ancestor := func(seqNum uint64) chain.Block {
+		b, _ := ns.chain.Get(seqNum)
+		return b
+	}
+	if n, ok := ns.tree.nodes[parent.Hash]; ok {
+		ancestor = ns.tree.ancestorFunc(n)
+	}
+	now := ns.clock.Now().Unix()
+	if mtp := chain.MedianTimePast(parent, ancestor); now <= mtp {
+		now = mtp + 1
+	}
+	b.SeqNum = parent.SeqNum + 1
+	// db is at our tip; if parent isn't it, a new tip is on its way
+	// through workerChannel and this block will be dropped anyway. What a
+	// block does can depend on its SeqNum (revocations record it), so
+	// that has to be set first.
+	db := ns.db.Copy()
+	chain.ApplyBlock(db, b)
+
+	b.Timestamp = now
+	b.Difficulty = ns.params.NextDifficulty(parent, ancestor)
+	b.StateRoot = db.Root()
+}
+
+// Miner does the proof of work for b on top of parent, like
+// chain.Block.SetProofOfWork: if a new tip comes in on tips first, it gives
+// up and returns that, with true
+type Miner func(b *chain.Block, parent [sha256.Size]byte, tips chan chain.Block) (chain.Block, bool)
+
+// SolveBlock is the real Miner, grinding nonces until the hash is good
+func SolveBlock(b *chain.Block, parent [sha256.Size]byte, tips chan chain.Block) (chain.Block, bool) {
+	return b.SetProofOfWork(parent, tips)
+}
+
+// build a block from the mempool, compute the proof of work and then add
+// the block to our queue
+func (ns *NodeServer) WorkOnBlock(pBlock chain.Block) {
+	for !ns.isdead() {
+		select {
+		// keep up with the tip even while there is nothing to mine
+		case pBlock = <-ns.workerChannel:
+			continue
+		default:
+		}
+		txns := ns.mempool.Template(MaxBlockTxns)
+		if len(txns) == 0 {
+			<-ns.clock.After(IdleWait)
+			continue
+		}
+		b := chain.Block{Transactions: txns}
+		ns.prepareBlock(&b, pBlock)
+		newParent, dropped := ns.miner(&b, pBlock.Hash, ns.workerChannel)
+		if dropped {
+			// we found a block in the channel, so start over on top of it
+			// with whatever the pool holds now
+			pBlock = newParent
+			continue
+		}
+		ns.qMu.Lock()
+		ns.blkQueue.Push(b)
+		ns.qMu.Unlock()
+		// peers fetch it from us if they haven't got it yet
+		item := rpc.InvItem{Type: rpc.InvBlock, Hash: b.Hash}
+		ns.seen.add(item, nil)
+		ns.announce([]rpc.InvItem{item}, "")
+		// its transactions stay in the pool until it is on our chain, so
+		// wait for that rather than mine them all over again
+		select {
+		case pBlock = <-ns.workerChannel:
+		case <-ns.clock.After(MinedWait):
+		}
+	}
+}
@@ -0,0 +1,293 @@
+package node
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/tslilyai/SLYkey/rpc"
+)
+
+const (
+	// how often we check on our peers and look for new ones
+	PeerInterval = 30 * time.Second
+	// a peer that fails this many checks in a row is dropped
+	MaxPeerFailures = 3
+	// most addresses handed out per GetPeers
+	MaxPeerAddrs = 256
+	// forget addresses we haven't reached in this long
+	PeerExpiry = 7 * 24 * time.Hour
+)
+
+type PeerInfo struct {
+	Addr     string
+	LastSeen time.Time // last time it answered us
+	Failures int       // failed checks since then
+}
+
+// PeerDB is every peer address we have heard of, whether or not we are
+// connected to it. With a path it is saved to disk on every change so a
+// restarted node doesn't have to start over from its seeds.
+type PeerDB struct {
+	mu    sync.Mutex
+	path  string
+	peers map[string]*PeerInfo
+	clock Clock // the clock of the node using it
+}
+
+// OpenPeerDB loads the peer database at path ("" for one kept in memory)
+func OpenPeerDB(path string) (*PeerDB, error) {
+	db := &PeerDB{path: path, peers: make(map[string]*PeerInfo), clock: RealClock{}}
+	if path == "" {
+		return db, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return db, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var infos []PeerInfo
+	if err := json.Unmarshal(data, &infos); err != nil {
+		return nil, err
+	}
+	for i := range infos {
+		db.peers[infos[i].Addr] = &infos[i]
+	}
+	return db, nil
+}
+
+func (db *PeerDB) setClock(clock Clock) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.clock = clock
+}
+
+// Precondition: mu acquired
+func (db *PeerDB) save() {
+	if db.path == "" {
+		return
+	}
+	infos := make([]PeerInfo, 0, len(db.peers))
+	for _, p := range db.peers {
+		infos = append(infos, *p)
+	}
+	data, err := json.Marshal(infos)
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	// write-then-rename so a crash leaves the old file intact
+	tmp := db.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		log.Print(err)
+		return
+	}
+	if err := os.Rename(tmp, db.path); err != nil {
+		log.Print(err)
+	}
+}
+
+// Add records addresses we were told about
+func (db *PeerDB) Add(addrs ...string) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	changed := false
+	for _, addr := range addrs {
+		if _, ok := db.peers[addr]; !ok && addr != "" {
+			db.peers[addr] = &PeerInfo{Addr: addr}
+			changed = true
+		}
+	}
+	if changed {
+		db.save()
+	}
+}
+
+func (db *PeerDB) Seen(addr string) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	p, ok := db.peers[addr]
+	if !ok {
+		p = &PeerInfo{Addr: addr}
+		db.peers[addr] = p
+	}
+	p.LastSeen = db.clock.Now()
+	p.Failures = 0
+	db.save()
+}
+
+// Reached says whether we have ever had an answer from addr
+func (db *PeerDB) Reached(addr string) bool {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	p, ok := db.peers[addr]
+	return ok && !p.LastSeen.IsZero()
+}
+
+// Failed counts a failed check against addr and returns the count
+func (db *PeerDB) Failed(addr string) int {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	p, ok := db.peers[addr]
+	if !ok {
+		return 0
+	}
+	p.Failures++
+	// never reached and keeps failing, or gone for too long
+	if (p.LastSeen.IsZero() && p.Failures >= MaxPeerFailures) ||
+		(!p.LastSeen.IsZero() && db.clock.Now().Sub(p.LastSeen) > PeerExpiry) {
+		delete(db.peers, addr)
+	}
+	db.save()
+	return p.Failures
+}
+
+// Addrs returns up to n addresses, most recently seen first
+func (db *PeerDB) Addrs(n int) []string {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	infos := make([]*PeerInfo, 0, len(db.peers))
+	for _, p := range db.peers {
+		infos = append(infos, p)
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].LastSeen.After(infos[j].LastSeen)
+	})
+	var addrs []string
+	for i := 0; i < len(infos) && i < n; i++ {
+		addrs = append(addrs, infos[i].Addr)
+	}
+	return addrs
+}
+
+// RPC methods for peer exchange
+func (ns *NodeServer) RequestPeers(remote string) (bool, []string) {
+	args := rpc.GetPeersArgs{From: ns.addr}
+	reply := rpc.GetPeersReply{}
+	ok := ns.pool.Call(remote, "ns.GetPeers", args, &reply)
+	if ok && reply.Status == rpc.ErrOK {
+		return true, reply.Peers
+	}
+	return false, nil
+}
+
+func (ns *NodeServer) GetPeers(args *rpc.GetPeersArgs, reply *rpc.GetPeersReply) error {
+	// whoever asks may be a live node worth knowing about, once it answers
+	// at the address it gave
+	go ns.checkPeer(args.From)
+	reply.Peers = ns.peerDB.Addrs(MaxPeerAddrs)
+	reply.Status = rpc.ErrOK
+	return nil
+}
+
+// checkPeer says whether addr, which a caller gave as its own, is a node
+// we can reach, asking it if we never have before and saving it if so.
+// Without that anyone could fill our peer database, or have us fetch from
+// whatever address they like. (Over TLS the RPC server has already blanked
+// an address the caller's certificate isn't for.)
+func (ns *NodeServer) checkPeer(addr string) bool {
+	if addr == "" || addr == ns.addr {
+		return false
+	}
+	if ns.peerDB.Reached(addr) {
+		return true
+	}
+	ns.pMu.Lock()
+	if done, ok := ns.checking[addr]; ok {
+		// one ask at a time
+		ns.pMu.Unlock()
+		<-done
+		return ns.peerDB.Reached(addr)
+	}
+	done := make(chan struct{})
+	ns.checking[addr] = done
+	ns.pMu.Unlock()
+	defer func() {
+		ns.pMu.Lock()
+		delete(ns.checking, addr)
+		ns.pMu.Unlock()
+		close(done)
+	}()
+
+	if ok, _ := ns.RequestPeers(addr); !ok {
+		return false
+	}
+	ns.peerDB.Seen(addr)
+	return true
+}
+
+// Peers returns the peers we are currently connected to
+func (ns *NodeServer) Peers() []string {
+	ns.pMu.Lock()
+	defer ns.pMu.Unlock()
+	return append([]string(nil), ns.peers...)
+}
+
+// ManagePeers runs in the background: every PeerInterval it checks on our
+// peers, drops the ones that stopped answering, swaps addresses with them,
+// and tops our connections back up to targetPeers from the peer database
+func (ns *NodeServer) ManagePeers() {
+	for !ns.isdead() {
+		ns.refreshPeers()
+		<-ns.clock.After(PeerInterval)
+	}
+}
+
+func (ns *NodeServer) refreshPeers() {
+	// seeds may have been forgotten while they were down; never give up on them
+	ns.peerDB.Add(ns.seeds...)
+
+	// check on the peers we have, learning theirs as we go
+	var alive []string
+	for _, peer := range ns.Peers() {
+		if ok, addrs := ns.RequestPeers(peer); ok {
+			ns.peerDB.Seen(peer)
+			ns.peerDB.Add(addrs...)
+			alive = append(alive, peer)
+		} else if ns.peerDB.Failed(peer) < MaxPeerFailures {
+			alive = append(alive, peer)
+		} else {
+			log.Printf("%v: dropping unreachable peer %v", ns.addr, peer)
+		}
+	}
+
+	// fill up from the peer database, in random order so a cluster doesn't
+	// all pile onto the same few nodes
+	candidates := ns.peerDB.Addrs(MaxPeerAddrs)
+	ns.rand.Shuffle(len(candidates), func(i, j int) {
+		candidates[i], candidates[j] = candidates[j], candidates[i]
+	})
+	for _, addr := range candidates {
+		if len(alive) >= ns.targetPeers {
+			break
+		}
+		if addr == ns.addr || contains(alive, addr) {
+			continue
+		}
+		if ok, addrs := ns.RequestPeers(addr); ok {
+			ns.peerDB.Seen(addr)
+			ns.peerDB.Add(addrs...)
+			alive = append(alive, addr)
+		} else {
+			ns.peerDB.Failed(addr)
+		}
+	}
+
+	ns.pMu.Lock()
+	ns.peers = alive
+	ns.pMu.Unlock()
+}
+
+func contains(list []string, s string) bool {
+	for _, x := range list {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
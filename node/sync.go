@@ -0,0 +1,360 @@
+package node
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"log"
+	"math/big"
+	"time"
+
+	"github.com/tslilyai/SLYkey/chain"
+	"github.com/tslilyai/SLYkey/rpc"
+)
+
+const (
+	// how often we check whether a peer has a better chain than ours
+	SyncInterval = 10 * time.Second
+	// blocks fetched per RequestBlockRange
+	BlockBatch = 64
+	MAX_BLOCKS = BlockBatch
+	// batches we keep in flight to any one peer
+	SyncWindow = 2
+	// how long a peer gets to answer a batch
+	SyncTimeout = 10 * time.Second
+	// a peer that fails this many batches stops getting asked
+	MaxSyncFailures = 3
+)
+
+var errNotBetter = fmt.Errorf("not more work than our chain")
+
+// SyncStatus says how far along catching up with the network is
+type SyncStatus struct {
+	Syncing bool
+	Peer    string // whose header chain we are following
+	// our tip when the current round started, and the tip of the header
+	// chain we are fetching blocks for
+	StartSeqNum  uint64
+	TargetSeqNum uint64
+	Headers      uint64 // validated headers in this round
+	Blocks       uint64 // blocks fetched in this round
+	Started      time.Time
+	LastError    string
+}
+
+// SyncStatus returns a copy of the sync progress
+func (ns *NodeServer) SyncStatus() SyncStatus {
+	ns.sMu.Lock()
+	defer ns.sMu.Unlock()
+	return ns.syncStatus
+}
+
+func (ns *NodeServer) updateSync(fn func(s *SyncStatus)) {
+	ns.sMu.Lock()
+	defer ns.sMu.Unlock()
+	fn(&ns.syncStatus)
+}
+
+// ask the sync thread to run now instead of at the next SyncInterval
+func (ns *NodeServer) requestSync() {
+	select {
+	case ns.syncNeeded <- struct{}{}:
+	default:
+	}
+}
+
+// RPC methods for sync
+func (ns *NodeServer) RequestHeaders(remote string, locator [][sha256.Size]byte) (bool, uint64, []chain.BlockHeader) {
+	args := rpc.RequestHeadersArgs{Locator: locator, Count: MAX_HEADERS}
+	reply := rpc.RequestHeadersReply{}
+	ok := ns.pool.CallTimeout(remote, "ns.RemoteHeaderLookup", args, &reply, SyncTimeout)
+	if ok && reply.Status == rpc.ErrFound {
+		return true, reply.From, reply.Headers
+	}
+	return false, 0, nil
+}
+
+func (ns *NodeServer) RequestBlockRange(remote string, from uint64, count uint64) (bool, []chain.Block) {
+	args := rpc.RequestBlockRangeArgs{From: from, Count: count}
+	reply := rpc.RequestBlockRangeReply{}
+	ok := ns.pool.CallTimeout(remote, "ns.RemoteBlockRange", args, &reply, SyncTimeout)
+	if ok && reply.Status == rpc.ErrFound {
+		return true, reply.Blocks
+	}
+	return false, nil
+}
+
+func (ns *NodeServer) RemoteBlockRange(args *rpc.RequestBlockRangeArgs, reply *rpc.RequestBlockRangeReply) error {
+	ns.mMu.Lock()
+	defer ns.mMu.Unlock()
+
+	count := args.Count
+	if count > MAX_BLOCKS {
+		count = MAX_BLOCKS
+	}
+	for seq := args.From; seq < args.From+count; seq++ {
+		b, ok := ns.chain.Get(seq)
+		if !ok {
+			break
+		}
+		reply.Blocks = append(reply.Blocks, b)
+	}
+	if len(reply.Blocks) == 0 {
+		return fmt.Errorf(rpc.ErrNotFound)
+	}
+	reply.Status = rpc.ErrFound
+	return nil
+}
+
+// End of RPC methods for sync
+
+// Precondition: mMu acquired
+// hashes of our main chain going back from the tip, densely at first and
+// then doubling the step, so a peer can find where our chains part ways
+func (ns *NodeServer) locator() [][sha256.Size]byte {
+	var hashes [][sha256.Size]byte
+	n := ns.tree.tip
+	step := uint64(1)
+	for n != nil {
+		hashes = append(hashes, n.block.Hash)
+		if len(hashes) >= 10 {
+			step *= 2
+		}
+		for i := uint64(0); i < step && n != nil; i++ {
+			n = n.parent
+		}
+	}
+	return hashes
+}
+
+// SyncBlocks runs in the background, catching up whenever a peer has a
+// chain with more work than ours: first its headers, then the blocks for
+// them in parallel batches from every peer
+func (ns *NodeServer) SyncBlocks() {
+	for !ns.isdead() {
+		// keep going while rounds make progress
+		for !ns.isdead() && ns.syncRound() {
+		}
+		select {
+		case <-ns.syncNeeded:
+		case <-ns.clock.After(SyncInterval):
+		}
+	}
+}
+
+// one round: returns true if we got anywhere
+func (ns *NodeServer) syncRound() bool {
+	ns.mMu.Lock()
+	locator := ns.locator()
+	start := ns.tree.Tip()
+	ns.mMu.Unlock()
+
+	// find the peer with the most work on top of what we share
+	var (
+		bestPeer    string
+		bestFrom    uint64
+		bestHeaders []chain.BlockHeader
+		bestWork    *big.Int
+	)
+	for _, peer := range ns.Peers() {
+		ok, from, headers := ns.RequestHeaders(peer, locator)
+		if !ok || len(headers) == 0 {
+			continue
+		}
+		work, err := ns.checkHeaders(from, headers)
+		if err == errNotBetter {
+			continue
+		} else if err != nil {
+			log.Printf("%v: bad headers from %v: %v", ns.addr, peer, err)
+			continue
+		}
+		if bestWork == nil || work.Cmp(bestWork) > 0 {
+			bestPeer, bestFrom, bestHeaders, bestWork = peer, from, headers, work
+		}
+	}
+	if bestWork == nil {
+		return false
+	}
+
+	ns.updateSync(func(s *SyncStatus) {
+		*s = SyncStatus{
+			Syncing:      true,
+			Peer:         bestPeer,
+			StartSeqNum:  start.SeqNum,
+			TargetSeqNum: bestFrom + uint64(len(bestHeaders)) - 1,
+			Headers:      uint64(len(bestHeaders)),
+			Started:      ns.clock.Now(),
+		}
+	})
+	err := ns.fetchBodies(bestFrom, bestHeaders)
+	ns.updateSync(func(s *SyncStatus) {
+		s.Syncing = false
+		if err != nil {
+			s.LastError = err.Error()
+		}
+	})
+
+	ns.mMu.Lock()
+	tip := ns.tree.Tip()
+	ns.mMu.Unlock()
+	if tip.Hash != start.Hash {
+		ns.newTip(tip)
+	}
+	return err == nil && tip.Hash != start.Hash
+}
+
+// checkHeaders validates a run of headers starting at block from, which
+// must build on a block we have, and returns the total work of that branch.
+// It is an error if that isn't more than the work of our tip.
+func (ns *NodeServer) checkHeaders(from uint64, headers []chain.BlockHeader) (*big.Int, error) {
+	ns.mMu.Lock()
+	defer ns.mMu.Unlock()
+
+	fork, ok := ns.tree.nodes[headers[0].ParentHash]
+	if !ok || fork.block.SeqNum+1 != from {
+		return nil, fmt.Errorf("headers don't build on a block we have")
+	}
+	// the branch so far: our blocks up to the fork, then the headers
+	branch := make([]chain.Block, 0, len(headers))
+	ancestor := func(seqNum uint64) chain.Block {
+		if seqNum >= from {
+			return branch[seqNum-from]
+		}
+		return ns.tree.ancestorFunc(fork)(seqNum)
+	}
+	parent := fork.block
+	work := new(big.Int).Set(fork.work)
+	now := ns.clock.Now().Unix()
+	for i, h := range headers {
+		b := chain.Block{BlockHeader: h, SeqNum: from + uint64(i), Hash: h.Hash()}
+		if h.ParentHash != parent.Hash || h.Version != chain.BlockVersion {
+			return nil, fmt.Errorf("header %v doesn't link up", b.SeqNum)
+		}
+		if h.Difficulty != ns.params.NextDifficulty(parent, ancestor) || !chain.HashMeetsDifficulty(b.Hash, h.Difficulty) {
+			return nil, fmt.Errorf("header %v: bad proof of work", b.SeqNum)
+		}
+		if !chain.CheckTimestamp(b, chain.MedianTimePast(parent, ancestor), now) {
+			return nil, fmt.Errorf("header %v: bad timestamp", b.SeqNum)
+		}
+		work.Add(work, chain.BlockWork(b))
+		branch = append(branch, b)
+		parent = b
+	}
+	if work.Cmp(ns.tree.tip.work) <= 0 {
+		return nil, errNotBetter
+	}
+	return work, nil
+}
+
+type syncBatch struct {
+	index int
+	from  uint64
+	count uint64
+}
+
+type syncResult struct {
+	batch  syncBatch
+	blocks []chain.Block
+	ok     bool
+	quit   bool // the worker gave up on its peer
+}
+
+// fetch the blocks for headers (the first being block from) in batches,
+// SyncWindow at a time from each peer, and process them in order as they
+// come in. Blocks have to match the headers we already checked.
+func (ns *NodeServer) fetchBodies(from uint64, headers []chain.BlockHeader) error {
+	var batches []syncBatch
+	for i := 0; i < len(headers); i += BlockBatch {
+		n := len(headers) - i
+		if n > BlockBatch {
+			n = BlockBatch
+		}
+		batches = append(batches, syncBatch{len(batches), from + uint64(i), uint64(n)})
+	}
+	peers := ns.Peers()
+	workers := len(peers) * SyncWindow
+	jobs := make(chan syncBatch, len(batches))
+	// room for every result a worker could ever send, so none of them
+	// gets stuck once we stop listening
+	results := make(chan syncResult, len(batches)+workers*(MaxSyncFailures+1))
+	for _, b := range batches {
+		jobs <- b
+	}
+
+	for _, peer := range peers {
+		for w := 0; w < SyncWindow; w++ {
+			go func(peer string) {
+				failures := 0
+				for job := range jobs {
+					ok, blocks := ns.RequestBlockRange(peer, job.from, job.count)
+					if ok && matchHeaders(blocks, headers[job.from-from:job.from-from+job.count]) {
+						results <- syncResult{batch: job, blocks: blocks, ok: true}
+						continue
+					}
+					results <- syncResult{batch: job}
+					if failures++; failures >= MaxSyncFailures {
+						results <- syncResult{quit: true}
+						return
+					}
+				}
+			}(peer)
+		}
+	}
+
+	pending := make(map[int][]chain.Block)
+	next, done := 0, 0
+	for done < len(batches) {
+		if workers == 0 {
+			close(jobs)
+			return fmt.Errorf("ran out of peers with %v of %v batches left", len(batches)-done, len(batches))
+		}
+		r := <-results
+		if r.quit {
+			workers--
+			continue
+		}
+		if !r.ok {
+			// someone else can have a go at it
+			jobs <- r.batch
+			continue
+		}
+		pending[r.batch.index] = r.blocks
+		done++
+		for blocks, ok := pending[next]; ok; blocks, ok = pending[next] {
+			if err := ns.processSynced(blocks); err != nil {
+				close(jobs)
+				return err
+			}
+			delete(pending, next)
+			next++
+		}
+	}
+	close(jobs)
+	return nil
+}
+
+func matchHeaders(blocks []chain.Block, headers []chain.BlockHeader) bool {
+	if len(blocks) != len(headers) {
+		return false
+	}
+	for i := range blocks {
+		if blocks[i].BlockHeader != headers[i] || blocks[i].GetHash() != blocks[i].Hash {
+			return false
+		}
+	}
+	return true
+}
+
+func (ns *NodeServer) processSynced(blocks []chain.Block) error {
+	ns.mMu.Lock()
+	defer ns.mMu.Unlock()
+	for _, b := range blocks {
+		if !ns.blockSanityCheck(b) {
+			return fmt.Errorf("block %v failed the sanity check", b.SeqNum)
+		}
+		ns.processBlock(b)
+	}
+	ns.updateSync(func(s *SyncStatus) {
+		s.Blocks += uint64(len(blocks))
+	})
+	return nil
+}
@@ -0,0 +1,92 @@
+package rpc
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/gob"
+	"net"
+	"net/rpc"
+)
+
+// callerArgs are arguments that name the caller's own address (a From
+// field), which the callee goes on to save or call back
+type callerArgs interface {
+	caller() *string
+}
+
+func (a *GetPeersArgs) caller() *string { return &a.From }
+func (a *AnnounceArgs) caller() *string { return &a.From }
+
+// serverCodec is net/rpc's gob codec, except that it blanks a caller's From
+// that the connection doesn't vouch for, so a method never sees an address
+// the caller couldn't prove was its own. Over mutual TLS that means one the
+// caller's certificate is good for. Other connections carry no identity, so
+// From gets through as it is, and callees have to check it themselves
+// before trusting it (see package node).
+type serverCodec struct {
+	conn   net.Conn
+	dec    *gob.Decoder
+	enc    *gob.Encoder
+	encBuf *bufio.Writer
+	closed bool
+}
+
+func newServerCodec(conn net.Conn) *serverCodec {
+	buf := bufio.NewWriter(conn)
+	return &serverCodec{
+		conn:   conn,
+		dec:    gob.NewDecoder(conn),
+		enc:    gob.NewEncoder(buf),
+		encBuf: buf,
+	}
+}
+
+func (c *serverCodec) ReadRequestHeader(r *rpc.Request) error {
+	return c.dec.Decode(r)
+}
+
+func (c *serverCodec) ReadRequestBody(body interface{}) error {
+	if err := c.dec.Decode(body); err != nil {
+		return err
+	}
+	if a, ok := body.(callerArgs); ok && !vouches(c.conn, *a.caller()) {
+		*a.caller() = ""
+	}
+	return nil
+}
+
+func (c *serverCodec) WriteResponse(r *rpc.Response, body interface{}) error {
+	if err := c.enc.Encode(r); err != nil {
+		// can't keep the stream in sync after half a response
+		c.Close()
+		return err
+	}
+	if err := c.enc.Encode(body); err != nil {
+		c.Close()
+		return err
+	}
+	return c.encBuf.Flush()
+}
+
+func (c *serverCodec) Close() error {
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	return c.conn.Close()
+}
+
+// whether conn's peer is known to be at addr: over TLS its certificate has
+// to be for addr's host, and anything else can't say
+func vouches(conn net.Conn, addr string) bool {
+	tc, ok := conn.(*tls.Conn)
+	if !ok {
+		return true
+	}
+	certs := tc.ConnectionState().PeerCertificates
+	host, _, err := net.SplitHostPort(addr)
+	if len(certs) == 0 || err != nil {
+		return false
+	}
+	return certs[0].VerifyHostname(host) == nil
+}
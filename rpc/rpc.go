@@ -0,0 +1,153 @@
+// Package rpc is how SLYkey nodes talk to each other: the transports they
+// connect over, a connection pool, and the argument and reply types of every
+// RPC a node serves.
+package rpc
+
+import (
+	"crypto/sha256"
+	"log"
+	"net"
+	"net/rpc"
+
+	"github.com/tslilyai/SLYkey/chain"
+	"github.com/tslilyai/SLYkey/txn"
+)
+
+const (
+	ErrOK       = "OK"
+	ErrFound    = "Found"
+	ErrNotFound = "404"
+	ErrRejected = "Rejected"
+)
+
+// RPC argument/response format
+type RequestBlockArgs struct {
+	SeqNum uint64
+	// look the block up by Hash instead of SeqNum
+	ByHash bool
+	Hash   [sha256.Size]byte
+}
+
+type RequestBlockReply struct {
+	Status string
+	Block  chain.Block
+}
+
+// headers of main chain blocks From, From+1, ... (at most Count of them).
+// With a Locator, From is instead the block after the first hash in it that
+// is on the callee's main chain.
+type RequestHeadersArgs struct {
+	From    uint64
+	Count   uint64
+	Locator [][sha256.Size]byte
+}
+
+type RequestHeadersReply struct {
+	Status  string
+	From    uint64 // SeqNum of Headers[0]
+	Headers []chain.BlockHeader
+}
+
+// main chain blocks From, From+1, ... (at most Count of them)
+type RequestBlockRangeArgs struct {
+	From  uint64
+	Count uint64
+}
+
+type RequestBlockRangeReply struct {
+	Status string
+	Blocks []chain.Block
+}
+
+type RequestTxnProofArgs struct {
+	SeqNum uint64
+	Email  string
+}
+
+type RequestTxnProofReply struct {
+	Status string
+	Proof  chain.TxnProof
+}
+
+type RequestKeyProofArgs struct {
+	Email string
+}
+
+// Status is ErrFound whether or not the email is registered; the proof
+// says which
+type RequestKeyProofReply struct {
+	Status string
+	Proof  chain.KeyProof
+}
+
+// From is the caller's own address, so the callee can learn about it. It
+// is "" if the connection showed it to be someone else's.
+type GetPeersArgs struct {
+	From string
+}
+
+type GetPeersReply struct {
+	Status string
+	Peers  []string
+}
+
+type InvType int
+
+const (
+	InvBlock InvType = 1 + iota
+	InvTxn
+)
+
+// InvItem names a block (by header hash) or a transaction (by Hash) without
+// sending the thing itself
+type InvItem struct {
+	Type InvType
+	Hash [sha256.Size]byte
+}
+
+// From is the announcer's address, which is where we fetch from; "" as for
+// GetPeersArgs
+type AnnounceArgs struct {
+	From  string
+	Items []InvItem
+}
+
+type AnnounceReply struct {
+	Status string
+}
+
+type GetDataArgs struct {
+	Items []InvItem
+}
+
+// whichever of the items asked for the callee still has
+type GetDataReply struct {
+	Status string
+	Blocks []chain.Block
+	Txns   []txn.Transaction
+}
+
+// Serve answers RPCs on l with rcvr's methods (as "ns.Method") until l is
+// closed; dead says when an accept error is just us shutting down. A From
+// the connection shows to be false is blanked (see serverCodec).
+func Serve(l net.Listener, rcvr interface{}, dead func() bool) error {
+	rpcs := rpc.NewServer()
+	if err := rpcs.RegisterName("ns", rcvr); err != nil {
+		return err
+	}
+	// Start RPC listener thread
+	go func() {
+		for dead() == false {
+			conn, err := l.Accept()
+			if err == nil && dead() == false {
+				go rpcs.ServeCodec(newServerCodec(conn))
+			} else if err == nil {
+				conn.Close()
+			}
+			if err != nil && dead() == false {
+				log.Printf("%v accept: %v\n", l.Addr(), err.Error())
+			}
+		}
+	}()
+	return nil
+}
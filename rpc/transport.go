@@ -0,0 +1,289 @@
+package rpc
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/rpc"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	DialTimeout = 5 * time.Second
+)
+
+var ErrPoolClosed = errors.New("connection pool is closed")
+
+// Transport is how nodes reach each other: what an address means, and how
+// to listen on and dial one
+type Transport interface {
+	Listen(addr string) (net.Listener, error)
+	Dial(addr string) (net.Conn, error)
+}
+
+// Unpooled is a Transport that can ask for calls to remote not to share a
+// connection: each gets one of its own, hung up once the call is done. Calls
+// to one remote can then arrive in a different order than they were made,
+// which the simulator (package sim) uses to reorder messages.
+type Unpooled interface {
+	Unpooled(remote string) bool
+}
+
+// UnixTransport uses unix domain sockets; addresses are socket paths.
+// Handy for test clusters on one machine.
+type UnixTransport struct{}
+
+func (UnixTransport) Listen(addr string) (net.Listener, error) {
+	// clear out a socket left behind by an earlier run
+	os.Remove(addr)
+	return net.Listen("unix", addr)
+}
+
+func (UnixTransport) Dial(addr string) (net.Conn, error) {
+	return net.DialTimeout("unix", addr, DialTimeout)
+}
+
+// TCPTransport uses host:port addresses. With TLS set, every connection is
+// mutual TLS: both ends present a node certificate signed by one of the
+// configured roots (see LoadTLSConfig).
+type TCPTransport struct {
+	TLS *tls.Config
+}
+
+func (t TCPTransport) Listen(addr string) (net.Listener, error) {
+	if t.TLS == nil {
+		return net.Listen("tcp", addr)
+	}
+	return tls.Listen("tcp", addr, t.TLS)
+}
+
+func (t TCPTransport) Dial(addr string) (net.Conn, error) {
+	d := &net.Dialer{Timeout: DialTimeout}
+	if t.TLS == nil {
+		return d.Dial("tcp", addr)
+	}
+	cfg := t.TLS.Clone()
+	if host, _, err := net.SplitHostPort(addr); err == nil && cfg.ServerName == "" {
+		cfg.ServerName = host
+	}
+	return tls.DialWithDialer(d, "tcp", addr, cfg)
+}
+
+// LoadTLSConfig sets up mutual TLS for a node: certFile/keyFile is our node
+// certificate, and peers must present certificates signed by one of the
+// PEM encoded CAs in rootFiles (which also vouch for the servers we dial).
+func LoadTLSConfig(certFile string, keyFile string, rootFiles []string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	roots := x509.NewCertPool()
+	for _, f := range rootFiles {
+		pem, err := ioutil.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+		if !roots.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in %v", f)
+		}
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      roots,
+		ClientCAs:    roots,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// Clock is what a ConnPool times calls out by (node.Clock is one)
+type Clock interface {
+	After(d time.Duration) <-chan time.Time
+}
+
+type realClock struct{}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// ConnPool keeps one RPC client per remote address open and reuses it for
+// every call (an rpc.Client is fine with concurrent calls), unless the
+// transport is Unpooled for it. A connection that breaks is dropped and
+// dialled again on the next call.
+type ConnPool struct {
+	mu        sync.Mutex
+	transport Transport
+	clock     Clock
+	clients   map[string]*rpc.Client
+	// dials in flight, so callers for the same remote wait on one dial
+	// rather than all making their own, and nobody holds mu through one
+	dials  map[string]*dial
+	closed bool
+}
+
+type dial struct {
+	done chan struct{}
+	c    *rpc.Client
+	err  error
+}
+
+// clock times out CallTimeout; the wall clock if nil
+func NewConnPool(t Transport, clock Clock) *ConnPool {
+	if clock == nil {
+		clock = realClock{}
+	}
+	return &ConnPool{
+		transport: t,
+		clock:     clock,
+		clients:   make(map[string]*rpc.Client),
+		dials:     make(map[string]*dial),
+	}
+}
+
+// the client to call remote with, and whether it is pooled; the caller
+// closes one that isn't
+func (p *ConnPool) client(remote string) (*rpc.Client, bool, error) {
+	if u, ok := p.transport.(Unpooled); ok && u.Unpooled(remote) {
+		conn, err := p.transport.Dial(remote)
+		if err != nil {
+			return nil, false, err
+		}
+		return rpc.NewClient(conn), false, nil
+	}
+	p.mu.Lock()
+	if c, ok := p.clients[remote]; ok {
+		p.mu.Unlock()
+		return c, true, nil
+	}
+	if d, ok := p.dials[remote]; ok {
+		p.mu.Unlock()
+		<-d.done
+		return d.c, true, d.err
+	}
+	if p.closed {
+		p.mu.Unlock()
+		return nil, false, ErrPoolClosed
+	}
+	d := &dial{done: make(chan struct{})}
+	p.dials[remote] = d
+	p.mu.Unlock()
+
+	// a slow or unreachable remote only holds up its own callers
+	conn, err := p.transport.Dial(remote)
+
+	p.mu.Lock()
+	delete(p.dials, remote)
+	switch {
+	case err != nil:
+		d.err = err
+	case p.closed:
+		conn.Close()
+		d.err = ErrPoolClosed
+	default:
+		d.c = rpc.NewClient(conn)
+		p.clients[remote] = d.c
+	}
+	p.mu.Unlock()
+	close(d.done)
+	return d.c, true, d.err
+}
+
+// forget c, unless someone already replaced it
+func (p *ConnPool) drop(remote string, c *rpc.Client) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.clients[remote] == c {
+		delete(p.clients, remote)
+	}
+	c.Close()
+}
+
+// Call helper function:
+// Does what the name says :)
+// Parameters:
+//   remote: address of the remote server
+//   name:   full name of the method, like "ns.ReceiveBlock"
+//   args:   struct containing arguments; must match on the caller-callee sides
+//   reply:  *reference* to the struct to store response
+// Return value:
+//   true if successful, false if errors occurred
+// Example:
+//   // Arg and Reply are some custom struct types
+//   args Arg{}
+//   reply Reply{}
+//   ok := pool.Call("/tmp/slycoin-server1.sock", "ns.ReceiveBlock", args, &reply)
+// Goes over a pooled connection, dialled with the pool's transport.
+func (p *ConnPool) Call(remote string, name string, args interface{}, reply interface{}) bool {
+	// a pooled connection may have gone stale since we last used it, so
+	// give it one retry on a fresh one
+	for try := 0; try < 2; try++ {
+		c, pooled, err := p.client(remote)
+		if err != nil {
+			// a peer that isn't up isn't worth shouting about
+			if !errors.Is(err, syscall.ENOENT) && !errors.Is(err, syscall.ECONNREFUSED) && err != ErrPoolClosed {
+				fmt.Printf("RPC Dial() failed: %v\n", err)
+			}
+			return false
+		}
+		err = c.Call(name, args, reply)
+		if !pooled {
+			c.Close()
+		}
+		if err == nil {
+			return true
+		}
+		if _, ok := err.(rpc.ServerError); ok {
+			// the remote answered, just not the way we hoped
+			fmt.Println(err)
+			return false
+		}
+		if pooled {
+			p.drop(remote, c)
+		}
+	}
+	return false
+}
+
+// CallTimeout is Call, but gives up on the remote after timeout. The
+// connection is dropped too, since a reply may still be on its way.
+func (p *ConnPool) CallTimeout(remote string, name string, args interface{}, reply interface{}, timeout time.Duration) bool {
+	c, pooled, err := p.client(remote)
+	if err != nil {
+		return false
+	}
+	if !pooled {
+		defer c.Close()
+	}
+	call := c.Go(name, args, reply, make(chan *rpc.Call, 1))
+	select {
+	case <-call.Done:
+		if call.Error != nil {
+			if _, ok := call.Error.(rpc.ServerError); !ok {
+				p.drop(remote, c)
+			}
+			return false
+		}
+		return true
+	case <-p.clock.After(timeout):
+		p.drop(remote, c)
+		return false
+	}
+}
+
+// Close hangs up every pooled connection, and any dialled from now on
+func (p *ConnPool) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	for remote, c := range p.clients {
+		c.Close()
+		delete(p.clients, remote)
+	}
+}
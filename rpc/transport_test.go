@@ -0,0 +1,148 @@
+package rpc
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"net"
+	"net/rpc"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type Echo struct{}
+
+func (Echo) Echo(args *string, reply *string) error {
+	*reply = *args
+	return nil
+}
+
+// dials "stuck" never finish until release is closed; anything else gets
+// an in-memory connection to an Echo server
+type stuckTransport struct {
+	release chan struct{}
+	server  *rpc.Server
+}
+
+func (t *stuckTransport) Listen(addr string) (net.Listener, error) {
+	return nil, fmt.Errorf("not listening")
+}
+
+func (t *stuckTransport) Dial(addr string) (net.Conn, error) {
+	if addr == "stuck" {
+		<-t.release
+		return nil, fmt.Errorf("gave up on %v", addr)
+	}
+	c, s := net.Pipe()
+	go t.server.ServeConn(s)
+	return c, nil
+}
+
+func TestDialDoesNotBlockOtherRemotes(t *testing.T) {
+	server := rpc.NewServer()
+	server.Register(Echo{})
+	tr := &stuckTransport{release: make(chan struct{}), server: server}
+	p := NewConnPool(tr, nil)
+	defer p.Close()
+	// before Close, which may wait on the stuck dial
+	defer close(tr.release)
+
+	go p.Call("stuck", "Echo.Echo", "hi", new(string))
+	// let the stuck dial get going
+	time.Sleep(10 * time.Millisecond)
+
+	done := make(chan bool, 1)
+	go func() {
+		var reply string
+		done <- p.Call("fine", "Echo.Echo", "hi", &reply) && reply == "hi"
+	}()
+	select {
+	case ok := <-done:
+		if !ok {
+			t.Fatal("call to a reachable remote failed")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("a dial to one remote held up a call to another")
+	}
+}
+
+type whoami struct{}
+
+func (whoami) GetPeers(args *GetPeersArgs, reply *GetPeersReply) error {
+	reply.Peers = []string{args.From}
+	return nil
+}
+
+// a self-signed certificate for node.example and 127.0.0.1, trusted by
+// both ends
+func testTLSConfig(t *testing.T) *tls.Config {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "node.example"},
+		DNSNames:              []string{"node.example"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+	return &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+		RootCAs:      roots,
+		ClientCAs:    roots,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}
+}
+
+func TestFromMustMatchCertificate(t *testing.T) {
+	tr := TCPTransport{TLS: testTLSConfig(t)}
+	l, err := tr.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dead := int32(0)
+	defer l.Close()
+	defer atomic.StoreInt32(&dead, 1)
+	if err := Serve(l, whoami{}, func() bool { return atomic.LoadInt32(&dead) != 0 }); err != nil {
+		t.Fatal(err)
+	}
+	p := NewConnPool(tr, nil)
+	defer p.Close()
+
+	for from, want := range map[string]string{
+		"node.example:4000":  "node.example:4000",
+		"127.0.0.1:4000":     "127.0.0.1:4000",
+		"other.example:4000": "",
+		"node.example":       "",
+	} {
+		var reply GetPeersReply
+		if !p.Call(l.Addr().String(), "ns.GetPeers", GetPeersArgs{From: from}, &reply) {
+			t.Fatalf("call as %v failed", from)
+		}
+		if len(reply.Peers) != 1 || reply.Peers[0] != want {
+			t.Errorf("From %q came through as %q, want %q", from, reply.Peers, want)
+		}
+	}
+}
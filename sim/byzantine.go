@@ -0,0 +1,202 @@
+package sim
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"math/rand"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/tslilyai/SLYkey/chain"
+	"github.com/tslilyai/SLYkey/rpc"
+)
+
+// how often a Byzantine peer pesters the honest nodes
+const ByzantineInterval = 5 * time.Second
+
+// Byzantine is a peer that lies. It serves a chain with more work than the
+// honest one, whose headers check out (proof of work, difficulty,
+// timestamps) but whose blocks commit to directories nobody can build from
+// them. It keeps announcing that chain's tip, along with transactions it
+// won't hand over when asked.
+type Byzantine struct {
+	addr  string
+	sim   *Sim
+	store *chain.MemBlockStore
+	pool  *rpc.ConnPool
+	rand  *rand.Rand
+	l     net.Listener
+	dead  int32
+}
+
+// AddByzantine starts a lying peer at addr with a bogus chain of length
+// blocks, and introduces it to every honest node
+func (s *Sim) AddByzantine(addr string, length int) (*Byzantine, error) {
+	t := s.Net.Transport(addr)
+	b := &Byzantine{
+		addr:  addr,
+		sim:   s,
+		store: chain.NewMemBlockStore(s.Params.Genesis()),
+		pool:  rpc.NewConnPool(t, s.Clock),
+		rand:  rand.New(rand.NewSource(s.rand.Int63())),
+	}
+	if err := b.forge(length); err != nil {
+		return nil, err
+	}
+	l, err := t.Listen(addr)
+	if err != nil {
+		return nil, err
+	}
+	b.l = l
+	if err := rpc.Serve(l, b, b.isdead); err != nil {
+		return nil, err
+	}
+	s.byz = append(s.byz, b)
+	// calls take simulated time, which only passes while the caller runs
+	// the simulation, so make them in the background
+	go b.pester()
+	return b, nil
+}
+
+func (b *Byzantine) isdead() bool {
+	return atomic.LoadInt32(&b.dead) != 0
+}
+
+func (b *Byzantine) Shutdown() {
+	atomic.StoreInt32(&b.dead, 1)
+	b.l.Close()
+	b.pool.Close()
+}
+
+// Has says whether hash is one of the bogus blocks
+func (b *Byzantine) Has(hash [sha256.Size]byte) bool {
+	_, ok := b.store.GetByHash(hash)
+	return ok
+}
+
+// mine length blocks on genesis that follow every rule a header can show,
+// with made-up state roots
+func (b *Byzantine) forge(length int) error {
+	params := &b.sim.Params
+	ancestor := func(seqNum uint64) chain.Block {
+		a, _ := b.store.Get(seqNum)
+		return a
+	}
+	now := b.sim.Clock.Now().Unix()
+	for i := 1; i <= length; i++ {
+		parent := b.store.Tip()
+		blk := chain.Block{SeqNum: parent.SeqNum + 1}
+		blk.Timestamp = now - int64(length) + int64(i)
+		if mtp := chain.MedianTimePast(parent, ancestor); blk.Timestamp <= mtp {
+			blk.Timestamp = mtp + 1
+		}
+		blk.Difficulty = params.NextDifficulty(parent, ancestor)
+		b.rand.Read(blk.StateRoot[:])
+		blk.SetProofOfWork(parent.Hash, nil)
+		if err := b.store.Put(blk); err != nil {
+			return fmt.Errorf("forging block %v: %v", blk.SeqNum, err)
+		}
+	}
+	return nil
+}
+
+// introduce ourselves to the honest nodes, then announce the bogus tip and
+// a made-up transaction to all of them every ByzantineInterval
+func (b *Byzantine) pester() {
+	for _, peer := range b.sim.Nodes() {
+		// asking for peers is how a node gets to know the asker
+		b.pool.Call(peer, "ns.GetPeers", rpc.GetPeersArgs{From: b.addr}, &rpc.GetPeersReply{})
+	}
+	for !b.isdead() {
+		var fake [sha256.Size]byte
+		b.rand.Read(fake[:])
+		args := rpc.AnnounceArgs{From: b.addr, Items: []rpc.InvItem{
+			{Type: rpc.InvBlock, Hash: b.store.Tip().Hash},
+			{Type: rpc.InvTxn, Hash: fake},
+		}}
+		for _, peer := range b.sim.Nodes() {
+			b.pool.Call(peer, "ns.Announce", args, &rpc.AnnounceReply{})
+		}
+		<-b.sim.Clock.After(ByzantineInterval)
+	}
+}
+
+// RPC methods, answering like a node would
+func (b *Byzantine) GetPeers(args *rpc.GetPeersArgs, reply *rpc.GetPeersReply) error {
+	reply.Status = rpc.ErrOK
+	return nil
+}
+
+func (b *Byzantine) Announce(args *rpc.AnnounceArgs, reply *rpc.AnnounceReply) error {
+	reply.Status = rpc.ErrOK
+	return nil
+}
+
+// hands over bogus blocks, but never the transactions it announced
+func (b *Byzantine) GetData(args *rpc.GetDataArgs, reply *rpc.GetDataReply) error {
+	for _, item := range args.Items {
+		if blk, ok := b.store.GetByHash(item.Hash); ok && item.Type == rpc.InvBlock {
+			reply.Blocks = append(reply.Blocks, blk)
+		}
+	}
+	reply.Status = rpc.ErrOK
+	return nil
+}
+
+func (b *Byzantine) RemoteBlockLookup(args *rpc.RequestBlockArgs, reply *rpc.RequestBlockReply) error {
+	var ok bool
+	if args.ByHash {
+		reply.Block, ok = b.store.GetByHash(args.Hash)
+	} else {
+		reply.Block, ok = b.store.Get(args.SeqNum)
+	}
+	if !ok {
+		return fmt.Errorf(rpc.ErrNotFound)
+	}
+	reply.Status = rpc.ErrFound
+	return nil
+}
+
+func (b *Byzantine) RemoteHeaderLookup(args *rpc.RequestHeadersArgs, reply *rpc.RequestHeadersReply) error {
+	from := args.From
+	if len(args.Locator) > 0 {
+		from = 1
+		for _, hash := range args.Locator {
+			if blk, ok := b.store.GetByHash(hash); ok {
+				from = blk.SeqNum + 1
+				break
+			}
+		}
+	}
+	reply.From = from
+	for seq := from; seq < from+args.Count; seq++ {
+		blk, ok := b.store.Get(seq)
+		if !ok {
+			break
+		}
+		reply.Headers = append(reply.Headers, blk.BlockHeader)
+	}
+	if len(reply.Headers) == 0 {
+		return fmt.Errorf(rpc.ErrNotFound)
+	}
+	reply.Status = rpc.ErrFound
+	return nil
+}
+
+func (b *Byzantine) RemoteBlockRange(args *rpc.RequestBlockRangeArgs, reply *rpc.RequestBlockRangeReply) error {
+	for seq := args.From; seq < args.From+args.Count; seq++ {
+		blk, ok := b.store.Get(seq)
+		if !ok {
+			break
+		}
+		reply.Blocks = append(reply.Blocks, blk)
+	}
+	if len(reply.Blocks) == 0 {
+		return fmt.Errorf(rpc.ErrNotFound)
+	}
+	reply.Status = rpc.ErrFound
+	return nil
+}
+
+// End of RPC methods
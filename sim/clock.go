@@ -0,0 +1,80 @@
+package sim
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock is simulated time: it only moves when Advance is called. Nodes run
+// on it (it is a node.Clock), and so does the network's message delivery.
+type Clock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []timer
+	// called after every Advance, without mu
+	hooks []func()
+}
+
+type timer struct {
+	at time.Time
+	c  chan time.Time
+}
+
+func NewClock(start time.Time) *Clock {
+	return &Clock{now: start}
+}
+
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// After fires once the clock has been advanced by d
+func (c *Clock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- c.now
+		return ch
+	}
+	c.timers = append(c.timers, timer{at: c.now.Add(d), c: ch})
+	return ch
+}
+
+// Advance moves the clock forward by d, firing the timers that come due in
+// the order they were set for
+func (c *Clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	var due, left []timer
+	for _, t := range c.timers {
+		if t.at.After(c.now) {
+			left = append(left, t)
+		} else {
+			due = append(due, t)
+		}
+	}
+	c.timers = left
+	hooks := c.hooks
+	c.mu.Unlock()
+
+	sort.SliceStable(due, func(i, j int) bool {
+		return due[i].at.Before(due[j].at)
+	})
+	for _, t := range due {
+		t.c <- t.at
+	}
+	for _, fn := range hooks {
+		fn()
+	}
+}
+
+// onAdvance has fn called after every Advance
+func (c *Clock) onAdvance(fn func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.hooks = append(c.hooks, fn)
+}
@@ -0,0 +1,55 @@
+package sim
+
+import (
+	"crypto/rsa"
+	"math/big"
+
+	"github.com/tslilyai/SLYkey/ca"
+	"github.com/tslilyai/SLYkey/txn"
+)
+
+// NewKey makes a KeyBits RSA key from the simulation's seed. rsa.GenerateKey
+// won't do: it draws on the system's randomness whatever reader it is
+// given, so its keys differ from run to run.
+func (s *Sim) NewKey() (*rsa.PrivateKey, error) {
+	e := big.NewInt(65537)
+	one := big.NewInt(1)
+	for {
+		p, q := s.prime(KeyBits/2), s.prime(KeyBits/2)
+		if p.Cmp(q) == 0 {
+			continue
+		}
+		phi := new(big.Int).Mul(new(big.Int).Sub(p, one), new(big.Int).Sub(q, one))
+		d := new(big.Int).ModInverse(e, phi)
+		if d == nil {
+			continue
+		}
+		key := &rsa.PrivateKey{
+			PublicKey: rsa.PublicKey{N: new(big.Int).Mul(p, q), E: int(e.Int64())},
+			D:         d,
+			Primes:    []*big.Int{p, q},
+		}
+		key.Precompute()
+		return key, key.Validate()
+	}
+}
+
+// a prime of bits bits with the top two set, so that the product of two
+// of them has twice the bits
+func (s *Sim) prime(bits int) *big.Int {
+	buf := make([]byte, bits/8)
+	for {
+		s.keys.Read(buf)
+		buf[0] |= 0xc0
+		buf[len(buf)-1] |= 1
+		if p := new(big.Int).SetBytes(buf); p.ProbablyPrime(20) {
+			return p
+		}
+	}
+}
+
+// SignRegistration has the simulation's CA sign t's registration for the
+// network chainID (see ca.SignRegistration), with a nonce from the seed
+func (s *Sim) SignRegistration(t *txn.Transaction, chainID string) (*ca.Registration, error) {
+	return ca.SignRegistrationFrom(s.keys, s.caKey, t, chainID)
+}
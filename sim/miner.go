@@ -0,0 +1,29 @@
+package sim
+
+import (
+	"crypto/sha256"
+	"math/rand"
+	"time"
+
+	"github.com/tslilyai/SLYkey/chain"
+	"github.com/tslilyai/SLYkey/node"
+)
+
+// the longest a simulated miner takes to find a block
+const MaxBlockTime = 50 * time.Millisecond
+
+// Miner is a node.Miner that finds a block after a delay in simulated time,
+// drawn from a rand seeded with seed, rather than however long the proof of
+// work takes on this machine
+func (s *Sim) Miner(seed int64) node.Miner {
+	r := rand.New(rand.NewSource(seed))
+	return func(b *chain.Block, parent [sha256.Size]byte, tips chan chain.Block) (chain.Block, bool) {
+		select {
+		case tip := <-tips:
+			return tip, true
+		case <-s.Clock.After(time.Duration(r.Int63n(int64(MaxBlockTime)))):
+		}
+		// on the test network any hash will do, so this is quick
+		return b.SetProofOfWork(parent, tips)
+	}
+}
@@ -0,0 +1,311 @@
+package sim
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/tslilyai/SLYkey/rpc"
+)
+
+// Link says how messages get between two nodes. Every write on a connection
+// is a message with its own delay, so messages on different connections can
+// overtake each other; on one connection they keep their order, as over
+// TCP (and as the RPC stream needs).
+type Link struct {
+	MinDelay time.Duration
+	MaxDelay time.Duration
+	// give every call a connection of its own (see rpc.Unpooled) instead of
+	// sharing one per pair of nodes, so that calls between two nodes can
+	// overtake each other too
+	Reorder bool
+	// chance a message is lost. A lost message takes its connection down
+	// with it (there is no retransmission to hide it), so whatever call it
+	// was part of fails and the connection gets dialled again.
+	Loss float64
+}
+
+// Network carries the RPCs of simulated nodes. Delays are in simulated
+// time, and every choice it makes (delay, loss) comes from a seeded rand.
+type Network struct {
+	clock     *Clock
+	mu        sync.Mutex
+	rand      *rand.Rand
+	link      Link
+	listeners map[string]*listener
+	// which side of a partition each address is on; addresses not in it
+	// are all together on side 0
+	side  map[string]int
+	pipes map[*pipe]bool
+}
+
+func NewNetwork(clock *Clock, seed int64) *Network {
+	n := &Network{
+		clock:     clock,
+		rand:      rand.New(rand.NewSource(seed)),
+		link:      Link{MinDelay: time.Millisecond, MaxDelay: time.Millisecond},
+		listeners: make(map[string]*listener),
+		side:      make(map[string]int),
+		pipes:     make(map[*pipe]bool),
+	}
+	// readers wait on the clock for their messages to arrive
+	clock.onAdvance(n.wake)
+	return n
+}
+
+func (n *Network) SetLink(l Link) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.link = l
+}
+
+// Partition splits the network: from now on an address only reaches the
+// ones in its own group. Connections across groups break the next time
+// they are used.
+func (n *Network) Partition(groups ...[]string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.side = make(map[string]int)
+	for i, g := range groups {
+		for _, addr := range g {
+			n.side[addr] = i + 1
+		}
+	}
+}
+
+// Heal undoes Partition
+func (n *Network) Heal() {
+	n.Partition()
+}
+
+// Transport is how the node at addr reaches the network
+func (n *Network) Transport(addr string) rpc.Transport {
+	return endpoint{n, addr}
+}
+
+// Precondition: mu acquired
+func (n *Network) reachable(a string, b string) bool {
+	return n.side[a] == n.side[b]
+}
+
+// Precondition: mu acquired
+// the delay for one message, or false if it is lost
+func (n *Network) delivery(from string, to string) (time.Duration, bool) {
+	if !n.reachable(from, to) || n.rand.Float64() < n.link.Loss {
+		return 0, false
+	}
+	d := n.link.MinDelay
+	if spread := n.link.MaxDelay - n.link.MinDelay; spread > 0 {
+		d += time.Duration(n.rand.Int63n(int64(spread)))
+	}
+	return d, true
+}
+
+func (n *Network) wake() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for p := range n.pipes {
+		p.mu.Lock()
+		p.cond.Broadcast()
+		p.mu.Unlock()
+	}
+}
+
+// refused looks like a peer that isn't up, which nodes don't log about
+func refused(format string, args ...interface{}) error {
+	return fmt.Errorf("%v: %w", fmt.Sprintf(format, args...), syscall.ECONNREFUSED)
+}
+
+type endpoint struct {
+	net  *Network
+	addr string
+}
+
+func (e endpoint) Unpooled(remote string) bool {
+	e.net.mu.Lock()
+	defer e.net.mu.Unlock()
+	return e.net.link.Reorder
+}
+
+func (e endpoint) Listen(addr string) (net.Listener, error) {
+	e.net.mu.Lock()
+	defer e.net.mu.Unlock()
+	if _, ok := e.net.listeners[addr]; ok {
+		return nil, fmt.Errorf("%v is already taken", addr)
+	}
+	l := &listener{net: e.net, addr: addr, conns: make(chan *conn, 16), done: make(chan struct{})}
+	e.net.listeners[addr] = l
+	return l, nil
+}
+
+func (e endpoint) Dial(addr string) (net.Conn, error) {
+	e.net.mu.Lock()
+	defer e.net.mu.Unlock()
+	l, ok := e.net.listeners[addr]
+	if !ok || !e.net.reachable(e.addr, addr) {
+		return nil, refused("dial %v from %v", addr, e.addr)
+	}
+	there, back := newPipe(), newPipe()
+	client := &conn{net: e.net, local: e.addr, remote: addr, in: back, out: there}
+	server := &conn{net: e.net, local: addr, remote: e.addr, in: there, out: back}
+	select {
+	case l.conns <- server:
+	default:
+		return nil, refused("%v isn't accepting", addr)
+	}
+	e.net.pipes[there] = true
+	e.net.pipes[back] = true
+	return client, nil
+}
+
+type listener struct {
+	net   *Network
+	addr  string
+	conns chan *conn
+	once  sync.Once
+	done  chan struct{}
+}
+
+func (l *listener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.conns:
+		return c, nil
+	case <-l.done:
+		return nil, fmt.Errorf("%v: listener closed", l.addr)
+	}
+}
+
+func (l *listener) Close() error {
+	l.once.Do(func() {
+		l.net.mu.Lock()
+		delete(l.net.listeners, l.addr)
+		l.net.mu.Unlock()
+		close(l.done)
+	})
+	return nil
+}
+
+func (l *listener) Addr() net.Addr {
+	return simAddr(l.addr)
+}
+
+type simAddr string
+
+func (a simAddr) Network() string {
+	return "sim"
+}
+
+func (a simAddr) String() string {
+	return string(a)
+}
+
+// one direction of a connection: messages on their way, each readable
+// once the clock reaches its time
+type pipe struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	msgs   []message
+	last   time.Time // when the newest message arrives
+	closed bool
+}
+
+type message struct {
+	at   time.Time
+	data []byte
+}
+
+func newPipe() *pipe {
+	p := &pipe{}
+	p.cond = sync.NewCond(&p.mu)
+	return p
+}
+
+func (p *pipe) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	p.msgs = nil
+	p.cond.Broadcast()
+}
+
+type conn struct {
+	net    *Network
+	local  string
+	remote string
+	in     *pipe
+	out    *pipe
+}
+
+func (c *conn) Read(b []byte) (int, error) {
+	p := c.in
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for {
+		if p.closed {
+			return 0, io.EOF
+		}
+		if len(p.msgs) > 0 && !p.msgs[0].at.After(c.net.clock.Now()) {
+			n := copy(b, p.msgs[0].data)
+			if n < len(p.msgs[0].data) {
+				p.msgs[0].data = p.msgs[0].data[n:]
+			} else {
+				p.msgs = p.msgs[1:]
+			}
+			return n, nil
+		}
+		p.cond.Wait()
+	}
+}
+
+func (c *conn) Write(b []byte) (int, error) {
+	c.net.mu.Lock()
+	delay, ok := c.net.delivery(c.local, c.remote)
+	c.net.mu.Unlock()
+	if !ok {
+		c.Close()
+		return 0, fmt.Errorf("%v -> %v: message lost", c.local, c.remote)
+	}
+	at := c.net.clock.Now().Add(delay)
+
+	p := c.out
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return 0, io.ErrClosedPipe
+	}
+	if at.Before(p.last) {
+		at = p.last
+	}
+	p.last = at
+	p.msgs = append(p.msgs, message{at: at, data: append([]byte(nil), b...)})
+	p.cond.Broadcast()
+	return len(b), nil
+}
+
+// Close takes down both directions, like a reset
+func (c *conn) Close() error {
+	c.net.mu.Lock()
+	delete(c.net.pipes, c.in)
+	delete(c.net.pipes, c.out)
+	c.net.mu.Unlock()
+	c.in.close()
+	c.out.close()
+	return nil
+}
+
+func (c *conn) LocalAddr() net.Addr {
+	return simAddr(c.local)
+}
+
+func (c *conn) RemoteAddr() net.Addr {
+	return simAddr(c.remote)
+}
+
+// deadlines aren't simulated; nodes time calls out themselves
+func (c *conn) SetDeadline(t time.Time) error      { return nil }
+func (c *conn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *conn) SetWriteDeadline(t time.Time) error { return nil }
@@ -0,0 +1,670 @@
+package sim
+
+import (
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/tslilyai/SLYkey/chain"
+	"github.com/tslilyai/SLYkey/client"
+	"github.com/tslilyai/SLYkey/node"
+	"github.com/tslilyai/SLYkey/txn"
+)
+
+// Scenario is a scripted run of a simulated network. Run returns an error
+// if the network didn't behave.
+type Scenario struct {
+	Name string
+	Run  func(s *Sim) error
+}
+
+var Scenarios = []Scenario{
+	{"fork", Fork},
+	{"reorg", Reorg},
+	{"late-joiner", LateJoiner},
+	{"byzantine", ByzantinePeer},
+	{"signing", Signing},
+	{"replay", Replay},
+	{"revoke", Revoke},
+	{"recover", Recover},
+	{"keys", Keys},
+}
+
+// how long a network gets to mesh up, and to settle after a change
+const (
+	MeshTime   = time.Minute
+	SettleTime = 3 * time.Minute
+)
+
+// RunScenario runs sc on a fresh network seeded with seed
+func RunScenario(sc Scenario, seed int64) error {
+	s, err := New(seed)
+	if err != nil {
+		return err
+	}
+	defer s.Shutdown()
+	return sc.Run(s)
+}
+
+// Fork: a partitioned network grows a branch on each side, on slow and
+// lossy links. Once it heals everyone goes with the longer branch, and what
+// the shorter one registered is mined again on top of it.
+func Fork(s *Sim) error {
+	s.Net.SetLink(Link{MinDelay: 10 * time.Millisecond, MaxDelay: 150 * time.Millisecond, Loss: 0.01})
+	s.AddNode("a0", true)
+	s.AddNode("a1", false)
+	s.AddNode("b0", true)
+	s.AddNode("b1", false)
+	s.Run(MeshTime)
+	if _, err := s.Mine("a0", "alice@example.com"); err != nil {
+		return err
+	}
+	if err := s.WaitConverged(SettleTime); err != nil {
+		return err
+	}
+
+	a, b := []string{"a0", "a1"}, []string{"b0", "b1"}
+	s.Net.Partition(a, b)
+	bob, err := s.Mine("a0", "bob@example.com")
+	if err != nil {
+		return err
+	}
+	carol, err := s.Mine("a0", "carol@example.com")
+	if err != nil {
+		return err
+	}
+	dave, err := s.Mine("b0", "dave@example.com")
+	if err != nil {
+		return err
+	}
+	if err := s.WaitConverged(SettleTime, a...); err != nil {
+		return fmt.Errorf("side a: %v", err)
+	}
+	if err := s.WaitConverged(SettleTime, b...); err != nil {
+		return fmt.Errorf("side b: %v", err)
+	}
+	if s.Converged() == nil {
+		return fmt.Errorf("the sides agree while partitioned")
+	}
+
+	s.Net.Heal()
+	all := s.Nodes()
+	if err := s.WaitForKey("bob@example.com", &bob.PublicKey, SettleTime, all...); err != nil {
+		return err
+	}
+	if err := s.WaitForKey("carol@example.com", &carol.PublicKey, SettleTime, all...); err != nil {
+		return err
+	}
+	if err := s.WaitForKey("dave@example.com", &dave.PublicKey, SettleTime, all...); err != nil {
+		return fmt.Errorf("the losing side's registration wasn't mined again: %v", err)
+	}
+	return s.WaitConverged(SettleTime)
+}
+
+// Reorg: an isolated miner registers an email its own way and builds three
+// blocks, while the rest of the network registers the same email with
+// another key and builds four. After healing, the isolated miner unwinds
+// all its blocks: the email ends up with the majority's key, and the
+// miner's other registration and the update after it, one block later,
+// both survive by being mined again.
+func Reorg(s *Sim) error {
+	s.AddNode("main0", true)
+	s.AddNode("main1", false)
+	s.AddNode("lone", true)
+	s.Run(MeshTime)
+	if err := s.WaitConverged(SettleTime); err != nil {
+		return err
+	}
+
+	s.Net.Partition([]string{"main0", "main1"}, []string{"lone"})
+	if _, err := s.Mine("lone", "eve@example.com"); err != nil {
+		return err
+	}
+	first, err := s.Mine("lone", "frank@example.com")
+	if err != nil {
+		return err
+	}
+	frank, err := s.Update("lone", "frank@example.com", first)
+	if err != nil {
+		return err
+	}
+	if err := s.WaitForKey("frank@example.com", &frank.PublicKey, time.Minute, "lone"); err != nil {
+		return err
+	}
+	eve, err := s.Mine("main0", "eve@example.com")
+	if err != nil {
+		return err
+	}
+	for _, email := range []string{"gina@example.com", "hal@example.com", "ivy@example.com"} {
+		if _, err := s.Mine("main0", email); err != nil {
+			return err
+		}
+	}
+	// main1 has to have the blocks too: if it still held their transactions
+	// it would pass them on to lone, which could mine them and tie
+	if err := s.WaitConverged(SettleTime, "main0", "main1"); err != nil {
+		return err
+	}
+	loneTip := s.Node("lone").Tip()
+
+	s.Net.Heal()
+	if err := s.WaitForKey("eve@example.com", &eve.PublicKey, SettleTime, s.Nodes()...); err != nil {
+		return err
+	}
+	if err := s.WaitForKey("frank@example.com", &frank.PublicKey, SettleTime, s.Nodes()...); err != nil {
+		return err
+	}
+	if err := s.WaitConverged(SettleTime); err != nil {
+		return err
+	}
+	if b, ok := s.Node("lone").GetBlock(loneTip.SeqNum); ok && b.Hash == loneTip.Hash {
+		return fmt.Errorf("lone's old branch is still its main chain")
+	}
+	return nil
+}
+
+// LateJoiner: a node that shows up after the chain has grown catches up
+// with it, over links that reorder calls, so blocks can turn up before
+// their parents
+func LateJoiner(s *Sim) error {
+	s.Net.SetLink(Link{MinDelay: 5 * time.Millisecond, MaxDelay: 50 * time.Millisecond, Reorder: true})
+	s.AddNode("early0", true)
+	s.AddNode("early1", false)
+	s.Run(MeshTime)
+	for i := 0; i < 5; i++ {
+		if _, err := s.Mine("early0", fmt.Sprintf("user%v@example.com", i)); err != nil {
+			return err
+		}
+	}
+	if err := s.WaitConverged(SettleTime); err != nil {
+		return err
+	}
+	s.AddNode("late", false)
+	if err := s.WaitConverged(SettleTime); err != nil {
+		return err
+	}
+	if tip := s.Node("late").Tip(); tip.SeqNum != 5 {
+		return fmt.Errorf("late joiner is at block %v, not 5", tip.SeqNum)
+	}
+	return nil
+}
+
+// ByzantinePeer: a peer serving a longer chain with bogus directory roots
+// (and announcing things it won't deliver) doesn't pull the honest nodes
+// off their chain, and doesn't keep them from agreeing on it
+func ByzantinePeer(s *Sim) error {
+	s.AddNode("h0", true)
+	s.AddNode("h1", false)
+	s.AddNode("h2", false)
+	s.Run(MeshTime)
+	for _, email := range []string{"ivan@example.com", "judy@example.com"} {
+		if _, err := s.Mine("h0", email); err != nil {
+			return err
+		}
+	}
+	byz, err := s.AddByzantine("mallory", 10)
+	if err != nil {
+		return err
+	}
+	s.Run(MeshTime)
+	if _, err := s.Mine("h0", "ken@example.com"); err != nil {
+		return err
+	}
+	s.Run(MeshTime)
+	if err := s.WaitConverged(SettleTime); err != nil {
+		return err
+	}
+	for _, addr := range s.Nodes() {
+		tip := s.Node(addr).Tip()
+		if byz.Has(tip.Hash) {
+			return fmt.Errorf("%v took the bogus chain", addr)
+		}
+		if tip.SeqNum != 3 {
+			return fmt.Errorf("%v is at block %v, not 3", addr, tip.SeqNum)
+		}
+	}
+	return nil
+}
+
+// Signing: a registration signed by the CA and an update signed by the
+// client make it onto every node, whichever node they are handed to.
+// Signatures made for another network, or for another kind of transaction,
+// are refused.
+func Signing(s *Sim) error {
+	s.AddNode("m0", true)
+	s.AddNode("r0", false)
+	s.AddNode("r1", false)
+	s.Run(MeshTime)
+	email := "alice@example.com"
+	alice, err := s.Register("r0", email)
+	if err != nil {
+		return err
+	}
+	if err := s.WaitForKey(email, &alice.PublicKey, SettleTime, s.Nodes()...); err != nil {
+		return err
+	}
+	current, err := s.Update("r1", email, alice)
+	if err != nil {
+		return err
+	}
+	if err := s.WaitForKey(email, &current.PublicKey, SettleTime, s.Nodes()...); err != nil {
+		return err
+	}
+
+	key, err := s.NewKey()
+	if err != nil {
+		return err
+	}
+	other := chain.MainNetParams.ChainID
+	reg := txn.Transaction{Type: txn.Register, Email: "mallory@example.com", PublicKey: key.PublicKey}
+	signed, err := s.SignRegistration(&reg, other)
+	if err != nil {
+		return err
+	}
+	reg.Nonce, reg.Signature = signed.Nonce, signed.Signature
+	if s.Node("m0").SubmitTransaction(reg) == nil {
+		return fmt.Errorf("a registration signed for %v was taken", other)
+	}
+	sig, err := client.SignUpdate(current, email, &key.PublicKey, 2, other)
+	if err != nil {
+		return err
+	}
+	if s.Node("m0").UpdatePublicKey(key.PublicKey, sig, email) == nil {
+		return fmt.Errorf("an update signed for %v was taken", other)
+	}
+	// the current key signing a registration of the new one
+	wrong := txn.Transaction{Type: txn.Register, Email: email, PublicKey: key.PublicKey}
+	if err := txn.Sign(&wrong, current, s.Params.ChainID); err != nil {
+		return err
+	}
+	if s.Node("m0").UpdatePublicKey(key.PublicKey, wrong.Signature, email) == nil {
+		return fmt.Errorf("a registration signature passed for an update")
+	}
+
+	s.Run(MeshTime)
+	for _, addr := range s.Nodes() {
+		if !s.HasKey(addr, email, &current.PublicKey) {
+			return fmt.Errorf("%v lost %v's key", addr, email)
+		}
+	}
+	return s.Converged()
+}
+
+// Replay: alice moves to a new key and back again. Her first update is
+// still signed by the key she has, but it was number 1 and she's at 2, so
+// handing it in again gets nowhere. A CA signature doesn't carry over to a
+// registration with another nonce either. Neither an update nor a
+// registration without a key gets in.
+func Replay(s *Sim) error {
+	s.AddNode("m0", true)
+	s.AddNode("r0", false)
+	s.AddNode("r1", false)
+	s.Run(MeshTime)
+	email := "alice@example.com"
+	first, err := s.Register("r0", email)
+	if err != nil {
+		return err
+	}
+	if err := s.WaitForKey(email, &first.PublicKey, SettleTime, s.Nodes()...); err != nil {
+		return err
+	}
+	second, err := s.NewKey()
+	if err != nil {
+		return err
+	}
+	away, err := s.UpdateTxn(email, first, &second.PublicKey, 1)
+	if err != nil {
+		return err
+	}
+	if err := s.Node("r0").SubmitTransaction(away); err != nil {
+		return err
+	}
+	if err := s.WaitForKey(email, &second.PublicKey, SettleTime, s.Nodes()...); err != nil {
+		return err
+	}
+	back, err := s.UpdateTxn(email, second, &first.PublicKey, 2)
+	if err != nil {
+		return err
+	}
+	if err := s.Node("r1").SubmitTransaction(back); err != nil {
+		return err
+	}
+	if err := s.WaitForKey(email, &first.PublicKey, SettleTime, s.Nodes()...); err != nil {
+		return err
+	}
+	for _, addr := range s.Nodes() {
+		if s.Node(addr).SubmitTransaction(away) == nil {
+			return fmt.Errorf("%v took a replayed update", addr)
+		}
+	}
+
+	key, err := s.NewKey()
+	if err != nil {
+		return err
+	}
+	reg := txn.Transaction{Type: txn.Register, Email: "bob@example.com", PublicKey: key.PublicKey}
+	signed, err := s.SignRegistration(&reg, s.Params.ChainID)
+	if err != nil {
+		return err
+	}
+	reg.Nonce, reg.Signature = make([]byte, txn.NonceSize), signed.Signature
+	if s.Node("m0").SubmitTransaction(reg) == nil {
+		return fmt.Errorf("a CA signature passed with another nonce")
+	}
+	keyless, err := s.UpdateTxn(email, first, &rsa.PublicKey{}, 3)
+	if err != nil {
+		return err
+	}
+	if s.Node("m0").SubmitTransaction(keyless) == nil {
+		return fmt.Errorf("an update without a key was taken")
+	}
+	if _, err := s.SignRegistration(&txn.Transaction{Type: txn.Register, Email: "carol@example.com"}, s.Params.ChainID); err == nil {
+		return fmt.Errorf("the CA signed a registration without a key")
+	}
+
+	s.Run(MeshTime)
+	for _, addr := range s.Nodes() {
+		id, _ := s.Node(addr).GetIdentity(email)
+		if !s.HasKey(addr, email, &first.PublicKey) || id.Sequence != 2 {
+			return fmt.Errorf("%v has %v at sequence %v", addr, email, id.Sequence)
+		}
+	}
+	return s.Converged()
+}
+
+// Revoke: alice revokes her key without a new one. Every node then says
+// she's revoked, in the block the revocation went into, rather than not
+// registered, and proves it; her old key can't update or re-register her.
+func Revoke(s *Sim) error {
+	s.AddNode("m0", true)
+	s.AddNode("r0", false)
+	s.AddNode("r1", false)
+	s.Run(MeshTime)
+	email := "alice@example.com"
+	alice, err := s.Register("r0", email)
+	if err != nil {
+		return err
+	}
+	if err := s.WaitForKey(email, &alice.PublicKey, SettleTime, s.Nodes()...); err != nil {
+		return err
+	}
+	if err := s.Revoke("r1", email, alice); err != nil {
+		return err
+	}
+	revoked := func(addr string) *node.RevokedError {
+		var re *node.RevokedError
+		_, err := s.Node(addr).GetPublicKey(email)
+		errors.As(err, &re)
+		return re
+	}
+	ok := s.RunUntil(func() bool {
+		for _, addr := range s.Nodes() {
+			if revoked(addr) == nil {
+				return false
+			}
+		}
+		return true
+	}, SettleTime)
+	if !ok {
+		return fmt.Errorf("%v's revocation didn't get everywhere", email)
+	}
+	if err := s.WaitConverged(SettleTime); err != nil {
+		return err
+	}
+
+	at := revoked("m0").SeqNum
+	b, _ := s.Node("m0").GetBlock(at)
+	found := false
+	for _, t := range b.Transactions {
+		found = found || (t.Type == txn.Revoke && t.Email == email)
+	}
+	if !found {
+		return fmt.Errorf("block %v doesn't revoke %v", at, email)
+	}
+	for _, addr := range s.Nodes() {
+		if re := revoked(addr); re.SeqNum != at {
+			return fmt.Errorf("%v says %v was revoked in block %v, not %v", addr, email, re.SeqNum, at)
+		}
+		kp := s.Node(addr).LookupKey(email)
+		if !kp.Lookup.Found || !kp.Lookup.Identity.Revoked || kp.Lookup.Identity.RevokedAt != at {
+			return fmt.Errorf("%v doesn't prove %v revoked", addr, email)
+		}
+		if err := kp.Verify(kp.Header.Hash()); err != nil {
+			return fmt.Errorf("%v: %v", addr, err)
+		}
+		if _, err := s.Node(addr).GetPublicKey("nobody@example.com"); err != node.ErrNotRegistered {
+			return fmt.Errorf("%v says %v for an unregistered email", addr, err)
+		}
+	}
+
+	key, err := s.NewKey()
+	if err != nil {
+		return err
+	}
+	update, err := s.UpdateTxn(email, alice, &key.PublicKey, 2)
+	if err != nil {
+		return err
+	}
+	if s.Node("m0").SubmitTransaction(update) == nil {
+		return fmt.Errorf("a revoked key was updated")
+	}
+	if _, err := s.Register("m0", email); err == nil {
+		return fmt.Errorf("a revoked email was registered again")
+	}
+	return s.Converged()
+}
+
+// Recover: alice registers with a recovery key. A recovery she didn't ask
+// for is cancelled with her key; the next one isn't, and once its delay is
+// up every node has her at the new key and the old one is no good. Her
+// current key can't start a recovery, and nobody can recover an identity
+// without a recovery key. Bob's recovery waits the shortest delay, which
+// still leaves his key the next block to cancel it in.
+func Recover(s *Sim) error {
+	s.AddNode("m0", true)
+	s.AddNode("r0", false)
+	s.AddNode("r1", false)
+	s.Run(MeshTime)
+	email := "alice@example.com"
+	alice, recovery, err := s.RegisterRecoverable("r0", email, 3)
+	if err != nil {
+		return err
+	}
+	if err := s.WaitForKey(email, &alice.PublicKey, SettleTime, s.Nodes()...); err != nil {
+		return err
+	}
+	// runs until every node has (or hasn't) a recovery of email pending at
+	// sequence seq
+	pending := func(email string, want bool, seq uint64) error {
+		ok := s.RunUntil(func() bool {
+			for _, addr := range s.Nodes() {
+				id, _ := s.Node(addr).GetIdentity(email)
+				if (id.RecoverAt != 0) != want || id.Sequence != seq {
+					return false
+				}
+			}
+			return true
+		}, SettleTime)
+		if !ok {
+			return fmt.Errorf("%v's recovery isn't pending=%v at sequence %v everywhere", email, want, seq)
+		}
+		return nil
+	}
+
+	if _, err := s.Recover("r1", email, recovery); err != nil {
+		return err
+	}
+	if err := pending(email, true, 1); err != nil {
+		return err
+	}
+	if err := s.CancelRecovery("r0", email, alice); err != nil {
+		return err
+	}
+	if err := pending(email, false, 2); err != nil {
+		return err
+	}
+	for _, addr := range s.Nodes() {
+		if !s.HasKey(addr, email, &alice.PublicKey) {
+			return fmt.Errorf("%v lost %v's key to a cancelled recovery", addr, email)
+		}
+	}
+
+	next, err := s.Recover("r1", email, recovery)
+	if err != nil {
+		return err
+	}
+	if err := pending(email, true, 3); err != nil {
+		return err
+	}
+	id, _ := s.Node("m0").GetIdentity(email)
+	for i := 0; s.Node("m0").Tip().SeqNum < id.RecoverAt; i++ {
+		if _, err := s.Mine("m0", fmt.Sprintf("filler%v@example.com", i)); err != nil {
+			return err
+		}
+	}
+	if err := s.WaitForKey(email, &next.PublicKey, SettleTime, s.Nodes()...); err != nil {
+		return err
+	}
+
+	key, err := s.NewKey()
+	if err != nil {
+		return err
+	}
+	update, err := s.UpdateTxn(email, alice, &key.PublicKey, 4)
+	if err != nil {
+		return err
+	}
+	if s.Node("m0").SubmitTransaction(update) == nil {
+		return fmt.Errorf("a recovered-from key was updated")
+	}
+	if _, err := s.Recover("m0", email, next); err == nil {
+		return fmt.Errorf("a recovery signed by the current key was taken")
+	}
+	if _, err := s.Recover("m0", "filler0@example.com", recovery); err == nil {
+		return fmt.Errorf("an identity without a recovery key was recovered")
+	}
+
+	bobEmail := "bob@example.com"
+	bob, bobRecovery, err := s.RegisterRecoverable("r0", bobEmail, txn.MinRecoveryDelay)
+	if err != nil {
+		return err
+	}
+	if err := s.WaitForKey(bobEmail, &bob.PublicKey, SettleTime, s.Nodes()...); err != nil {
+		return err
+	}
+	if _, err := s.Recover("r1", bobEmail, bobRecovery); err != nil {
+		return err
+	}
+	if err := pending(bobEmail, true, 1); err != nil {
+		return err
+	}
+	if err := s.CancelRecovery("r0", bobEmail, bob); err != nil {
+		return fmt.Errorf("cancelling a recovery with the shortest delay: %v", err)
+	}
+	if err := pending(bobEmail, false, 2); err != nil {
+		return err
+	}
+	for _, addr := range s.Nodes() {
+		if !s.HasKey(addr, bobEmail, &bob.PublicKey) {
+			return fmt.Errorf("%v lost %v's key to a cancelled recovery", addr, bobEmail)
+		}
+	}
+	if err := s.WaitConverged(SettleTime); err != nil {
+		return err
+	}
+	return s.Converged()
+}
+
+// Keys: alice adds a laptop key that can manage her keys, and the laptop
+// adds a phone key for encryption only. Every node lists her keys by
+// purpose and proves the whole set. The phone can't change the set, and
+// once her primary key removes the laptop, neither can the laptop.
+func Keys(s *Sim) error {
+	s.AddNode("m0", true)
+	s.AddNode("r0", false)
+	s.AddNode("r1", false)
+	s.Run(MeshTime)
+	email := "alice@example.com"
+	alice, err := s.Register("r0", email)
+	if err != nil {
+		return err
+	}
+	if err := s.WaitForKey(email, &alice.PublicKey, SettleTime, s.Nodes()...); err != nil {
+		return err
+	}
+	// runs until every node's keys for purpose are labelled labels
+	waitKeys := func(purpose txn.Purpose, labels ...string) error {
+		ok := s.RunUntil(func() bool {
+			for _, addr := range s.Nodes() {
+				keys, err := s.Node(addr).GetKeys(email, purpose)
+				if err != nil || len(keys) != len(labels) {
+					return false
+				}
+				for i, k := range keys {
+					if k.Label != labels[i] {
+						return false
+					}
+				}
+			}
+			return true
+		}, SettleTime)
+		if !ok {
+			return fmt.Errorf("%v's %v keys aren't %v everywhere", email, purpose, labels)
+		}
+		return nil
+	}
+
+	laptop, err := s.AddKey("r0", email, alice, "laptop", txn.PurposeSign|txn.PurposeManage)
+	if err != nil {
+		return err
+	}
+	if err := waitKeys(txn.PurposeSign, txn.PrimaryLabel, "laptop"); err != nil {
+		return err
+	}
+	phone, err := s.AddKey("r1", email, laptop, "phone", txn.PurposeEncrypt)
+	if err != nil {
+		return err
+	}
+	if err := waitKeys(txn.PurposeEncrypt, txn.PrimaryLabel, "phone"); err != nil {
+		return err
+	}
+	if err := waitKeys(txn.PurposeManage, txn.PrimaryLabel, "laptop"); err != nil {
+		return err
+	}
+	if err := s.RemoveKey("m0", email, phone, "laptop"); err == nil {
+		return fmt.Errorf("a key without the manage purpose removed another")
+	}
+	if _, err := s.AddKey("m0", email, alice, "phone", txn.PurposeSign); err == nil {
+		return fmt.Errorf("a second key was labelled phone")
+	}
+
+	if err := s.RemoveKey("r0", email, alice, "laptop"); err != nil {
+		return err
+	}
+	if err := waitKeys(0, txn.PrimaryLabel, "phone"); err != nil {
+		return err
+	}
+	if _, err := s.AddKey("m0", email, laptop, "tablet", txn.PurposeSign); err == nil {
+		return fmt.Errorf("a removed key added another")
+	}
+	if err := s.WaitConverged(SettleTime); err != nil {
+		return err
+	}
+	for _, addr := range s.Nodes() {
+		kp := s.Node(addr).LookupKey(email)
+		if err := kp.Verify(kp.Header.Hash()); err != nil {
+			return fmt.Errorf("%v: %v", addr, err)
+		}
+		keys := kp.Lookup.Identity.Keys
+		if len(keys) != 1 || keys[0].Label != "phone" || keys[0].PublicKey.N.Cmp(phone.N) != 0 {
+			return fmt.Errorf("%v doesn't prove %v's key set", addr, email)
+		}
+		// leaving a key out of the set breaks the proof
+		kp.Lookup.Identity.Keys = nil
+		if kp.Verify(kp.Header.Hash()) == nil {
+			return fmt.Errorf("%v's proof holds without %v's phone key", addr, email)
+		}
+	}
+	return s.Converged()
+}
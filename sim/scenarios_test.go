@@ -0,0 +1,29 @@
+package sim
+
+import (
+	"flag"
+	"io/ioutil"
+	"log"
+	"testing"
+)
+
+var seed = flag.Int64("seed", 1, "seed for the simulated networks")
+
+func TestScenarios(t *testing.T) {
+	if testing.Short() {
+		t.Skip("scenarios take a while")
+	}
+	if !testing.Verbose() {
+		log.SetOutput(ioutil.Discard)
+	}
+	for _, sc := range Scenarios {
+		sc := sc
+		t.Run(sc.Name, func(t *testing.T) {
+			// each has a network and clock of its own
+			t.Parallel()
+			if err := RunScenario(sc, *seed); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
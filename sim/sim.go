@@ -0,0 +1,352 @@
+// Package sim runs whole SLYkey networks inside one process: nodes talk over
+// a simulated network with message delay, loss and partitions, on a clock
+// that only moves when the simulation moves it. Scenarios (see Scenarios)
+// script forks, reorganisations, late joiners and lying peers, and check
+// that the honest nodes end up agreeing on the chain and the directory.
+//
+// A seed fixes every random input: the keys and the CA's nonces (see
+// NewKey), so a scenario's transactions are the same bytes on every run; the
+// network's delays, losses and reordering; each node's choice of peers; how
+// long each miner takes to find a block (see Miner); and what a Byzantine
+// peer makes up. Timers all run on the simulated clock. What a seed doesn't
+// fix is the order the Go runtime runs the nodes' goroutines in. They get a
+// moment of real time (Pause) to react to each Step, and the network hands
+// out its draws in whatever order messages get sent, so a rerun sees the same
+// transactions under the same conditions, but its blocks can be mined at
+// other moments, with other timestamps and so other hashes.
+package sim
+
+import (
+	"crypto/rsa"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/tslilyai/SLYkey/chain"
+	"github.com/tslilyai/SLYkey/client"
+	"github.com/tslilyai/SLYkey/node"
+	"github.com/tslilyai/SLYkey/txn"
+)
+
+const (
+	// how far Run moves the clock at a time
+	Step = 10 * time.Millisecond
+	// real time the nodes get to react to each Step
+	Pause = 200 * time.Microsecond
+	// keys are only for show here, so keep them cheap to make
+	KeyBits = 1024
+)
+
+// where simulated time starts
+var Epoch = time.Date(2015, 12, 1, 0, 0, 0, 0, time.UTC)
+
+// Sim is a simulated network and the nodes on it
+type Sim struct {
+	Clock  *Clock
+	Net    *Network
+	Params chain.ChainParams
+	caKey  *rsa.PrivateKey
+	rand   *rand.Rand // seeds everything else that is random
+	keys   *rand.Rand // for keys and the CA's nonces
+	nodes  map[string]*node.NodeServer
+	addrs  []string // honest nodes, in the order they were added
+	byz    []*Byzantine
+}
+
+// New makes an empty network on the test network rules; seed drives every
+// choice the network and its nodes make
+func New(seed int64) (*Sim, error) {
+	clock := NewClock(Epoch)
+	r := rand.New(rand.NewSource(seed))
+	s := &Sim{
+		Clock:  clock,
+		Net:    NewNetwork(clock, r.Int63()),
+		Params: chain.TestNetParams,
+		rand:   r,
+		keys:   rand.New(rand.NewSource(r.Int63())),
+		nodes:  make(map[string]*node.NodeServer),
+	}
+	var err error
+	if s.caKey, err = s.NewKey(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// AddNode starts an honest node at addr that knows every node added before
+// it. Only nodes with mine set make blocks; the others validate and relay.
+// The only way a node can't listen here is a scenario reusing an address,
+// so that panics.
+func (s *Sim) AddNode(addr string, mine bool) *node.NodeServer {
+	ns, err := node.NewNodeServer(node.NodeConfig{
+		Addr:      addr,
+		Params:    &s.Params,
+		Clock:     s.Clock,
+		Rand:      rand.New(rand.NewSource(s.rand.Int63())),
+		NoMining:  !mine,
+		Miner:     s.Miner(s.rand.Int63()),
+		Seeds:     append([]string(nil), s.addrs...),
+		Transport: s.Net.Transport(addr),
+		CAKey:     &s.caKey.PublicKey,
+	})
+	if err != nil {
+		panic(err)
+	}
+	s.nodes[addr] = ns
+	s.addrs = append(s.addrs, addr)
+	return ns
+}
+
+func (s *Sim) Node(addr string) *node.NodeServer {
+	return s.nodes[addr]
+}
+
+// Nodes returns the addresses of the honest nodes
+func (s *Sim) Nodes() []string {
+	return append([]string(nil), s.addrs...)
+}
+
+// Run lets d of simulated time go by
+func (s *Sim) Run(d time.Duration) {
+	for end := s.Clock.Now().Add(d); s.Clock.Now().Before(end); {
+		s.Clock.Advance(Step)
+		time.Sleep(Pause)
+	}
+}
+
+// RunUntil runs until cond holds, for at most limit of simulated time, and
+// says whether it got there
+func (s *Sim) RunUntil(cond func() bool, limit time.Duration) bool {
+	for end := s.Clock.Now().Add(limit); ; {
+		if cond() {
+			return true
+		}
+		if !s.Clock.Now().Before(end) {
+			return false
+		}
+		s.Clock.Advance(Step)
+		time.Sleep(Pause)
+	}
+}
+
+// Register makes a key for email, has the CA sign its registration and
+// hands it to the node at addr
+func (s *Sim) Register(addr string, email string) (*rsa.PrivateKey, error) {
+	key, err := s.NewKey()
+	if err != nil {
+		return nil, err
+	}
+	t := txn.Transaction{Type: txn.Register, Email: email, PublicKey: key.PublicKey}
+	return key, s.register(addr, t)
+}
+
+// RegisterRecoverable is Register with a recovery key as well, which has
+// to wait delay blocks
+func (s *Sim) RegisterRecoverable(addr string, email string, delay uint64) (*rsa.PrivateKey, *rsa.PrivateKey, error) {
+	key, err := s.NewKey()
+	if err != nil {
+		return nil, nil, err
+	}
+	recovery, err := s.NewKey()
+	if err != nil {
+		return nil, nil, err
+	}
+	t := txn.Transaction{
+		Type:          txn.Register,
+		Email:         email,
+		PublicKey:     key.PublicKey,
+		RecoveryKey:   recovery.PublicKey,
+		RecoveryDelay: delay,
+	}
+	return key, recovery, s.register(addr, t)
+}
+
+func (s *Sim) register(addr string, t txn.Transaction) error {
+	reg, err := s.SignRegistration(&t, s.Params.ChainID)
+	if err != nil {
+		return err
+	}
+	t.Nonce, t.Signature = reg.Nonce, reg.Signature
+	return s.nodes[addr].SubmitTransaction(t)
+}
+
+// Update moves email from key current to a fresh one, through the node at
+// addr
+func (s *Sim) Update(addr string, email string, current *rsa.PrivateKey) (*rsa.PrivateKey, error) {
+	key, err := s.NewKey()
+	if err != nil {
+		return nil, err
+	}
+	id, ok := s.nodes[addr].GetIdentity(email)
+	if !ok {
+		return nil, fmt.Errorf("%v doesn't know %v", addr, email)
+	}
+	sig, err := client.SignUpdate(current, email, &key.PublicKey, id.Sequence+1, s.Params.ChainID)
+	if err != nil {
+		return nil, err
+	}
+	return key, s.nodes[addr].UpdatePublicKey(key.PublicKey, sig, email)
+}
+
+// Revoke revokes email's key current, through the node at addr
+func (s *Sim) Revoke(addr string, email string, current *rsa.PrivateKey) error {
+	id, ok := s.nodes[addr].GetIdentity(email)
+	if !ok {
+		return fmt.Errorf("%v doesn't know %v", addr, email)
+	}
+	sig, err := client.SignRevoke(current, email, &id.PublicKey, id.Sequence+1, s.Params.ChainID)
+	if err != nil {
+		return err
+	}
+	return s.nodes[addr].RevokePublicKey(sig, email)
+}
+
+// Recover starts moving email to a fresh key with its recovery key, through
+// the node at addr
+func (s *Sim) Recover(addr string, email string, recovery *rsa.PrivateKey) (*rsa.PrivateKey, error) {
+	key, err := s.NewKey()
+	if err != nil {
+		return nil, err
+	}
+	id, ok := s.nodes[addr].GetIdentity(email)
+	if !ok {
+		return nil, fmt.Errorf("%v doesn't know %v", addr, email)
+	}
+	sig, err := client.SignRecover(recovery, email, &id.PublicKey, &key.PublicKey, id.Sequence+1, s.Params.ChainID)
+	if err != nil {
+		return nil, err
+	}
+	return key, s.nodes[addr].RecoverPublicKey(key.PublicKey, sig, email)
+}
+
+// CancelRecovery cancels email's pending recovery with its key current,
+// through the node at addr
+func (s *Sim) CancelRecovery(addr string, email string, current *rsa.PrivateKey) error {
+	id, ok := s.nodes[addr].GetIdentity(email)
+	if !ok {
+		return fmt.Errorf("%v doesn't know %v", addr, email)
+	}
+	sig, err := client.SignCancelRecovery(current, email, id.Sequence+1, s.Params.ChainID)
+	if err != nil {
+		return err
+	}
+	return s.nodes[addr].CancelRecovery(sig, email)
+}
+
+// AddKey adds a fresh key to email's key set as label for purpose, signed
+// by signer, through the node at addr
+func (s *Sim) AddKey(addr string, email string, signer *rsa.PrivateKey, label string, purpose txn.Purpose) (*rsa.PrivateKey, error) {
+	key, err := s.NewKey()
+	if err != nil {
+		return nil, err
+	}
+	id, ok := s.nodes[addr].GetIdentity(email)
+	if !ok {
+		return nil, fmt.Errorf("%v doesn't know %v", addr, email)
+	}
+	sig, err := client.SignAddKey(signer, email, &id.PublicKey, &key.PublicKey, label, purpose, id.Sequence+1, s.Params.ChainID)
+	if err != nil {
+		return nil, err
+	}
+	return key, s.nodes[addr].AddKey(key.PublicKey, label, purpose, sig, email)
+}
+
+// RemoveKey removes the key labelled label from email's key set, signed by
+// signer, through the node at addr
+func (s *Sim) RemoveKey(addr string, email string, signer *rsa.PrivateKey, label string) error {
+	id, ok := s.nodes[addr].GetIdentity(email)
+	if !ok {
+		return fmt.Errorf("%v doesn't know %v", addr, email)
+	}
+	sig, err := client.SignRemoveKey(signer, email, &id.PublicKey, label, id.Sequence+1, s.Params.ChainID)
+	if err != nil {
+		return err
+	}
+	return s.nodes[addr].RemoveKey(label, sig, email)
+}
+
+// UpdateTxn is update number seq of email from current to key, signed
+func (s *Sim) UpdateTxn(email string, current *rsa.PrivateKey, key *rsa.PublicKey, seq uint64) (txn.Transaction, error) {
+	t := txn.Transaction{
+		Type:        txn.Update,
+		Email:       email,
+		PublicKey:   *key,
+		Sequence:    seq,
+		PrevKeyHash: txn.KeyHash(&current.PublicKey),
+	}
+	return t, txn.Sign(&t, current, s.Params.ChainID)
+}
+
+// HasKey says whether the node at addr has email at key
+func (s *Sim) HasKey(addr string, email string, key *rsa.PublicKey) bool {
+	got, err := s.nodes[addr].GetPublicKey(email)
+	return err == nil && got.N != nil && got.E == key.E && got.N.Cmp(key.N) == 0
+}
+
+// WaitForKey runs until every one of addrs has email at key
+func (s *Sim) WaitForKey(email string, key *rsa.PublicKey, limit time.Duration, addrs ...string) error {
+	ok := s.RunUntil(func() bool {
+		for _, addr := range addrs {
+			if !s.HasKey(addr, email, key) {
+				return false
+			}
+		}
+		return true
+	}, limit)
+	if !ok {
+		return fmt.Errorf("%v didn't get to %v within %v", email, addrs, limit)
+	}
+	return nil
+}
+
+// Mine registers email through the miner at addr and waits for it to be in
+// a block there, which makes one block since nothing else is pending
+func (s *Sim) Mine(addr string, email string) (*rsa.PrivateKey, error) {
+	key, err := s.Register(addr, email)
+	if err != nil {
+		return nil, err
+	}
+	return key, s.WaitForKey(email, &key.PublicKey, time.Minute, addr)
+}
+
+// Converged checks that the honest nodes at addrs (all of them if none are
+// given) are on the same tip, and that each one's directory is the one
+// that tip commits to
+func (s *Sim) Converged(addrs ...string) error {
+	if len(addrs) == 0 {
+		addrs = s.addrs
+	}
+	first := s.nodes[addrs[0]].Tip()
+	for _, addr := range addrs {
+		ns := s.nodes[addr]
+		tip := ns.Tip()
+		if root := ns.DirectoryRoot(); root != tip.StateRoot {
+			return fmt.Errorf("%v: directory root %x isn't its tip's %x", addr, root[:4], tip.StateRoot[:4])
+		}
+		if tip.Hash != first.Hash {
+			return fmt.Errorf("%v is at %v (%x) but %v is at %v (%x)",
+				addrs[0], first.SeqNum, first.Hash[:4], addr, tip.SeqNum, tip.Hash[:4])
+		}
+	}
+	return nil
+}
+
+// WaitConverged runs until the nodes at addrs (all if none) converge
+func (s *Sim) WaitConverged(limit time.Duration, addrs ...string) error {
+	if s.RunUntil(func() bool { return s.Converged(addrs...) == nil }, limit) {
+		return nil
+	}
+	return fmt.Errorf("no convergence within %v: %v", limit, s.Converged(addrs...))
+}
+
+// Shutdown stops every node, then runs the clock a little so their
+// background loops notice
+func (s *Sim) Shutdown() {
+	for _, b := range s.byz {
+		b.Shutdown()
+	}
+	for _, addr := range s.addrs {
+		s.nodes[addr].Shutdown()
+	}
+	s.Run(node.PeerInterval + Step)
+}
@@ -0,0 +1,35 @@
+package sim
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/tslilyai/SLYkey/txn"
+)
+
+// two simulations with one seed make the same keys and registrations
+func TestSeedFixesKeys(t *testing.T) {
+	var regs [2][]byte
+	for i := range regs {
+		s, err := New(7)
+		if err != nil {
+			t.Fatal(err)
+		}
+		key, err := s.NewKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		reg := txn.Transaction{Type: txn.Register, Email: "alice@example.com", PublicKey: key.PublicKey}
+		signed, err := s.SignRegistration(&reg, s.Params.ChainID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reg.Nonce, reg.Signature = signed.Nonce, signed.Signature
+		if regs[i], err = reg.MarshalBinary(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !bytes.Equal(regs[0], regs[1]) {
+		t.Fatal("the same seed made different registrations")
+	}
+}
@@ -0,0 +1,139 @@
+package txn
+
+import (
+	"crypto/rsa"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/big"
+)
+
+// EncodingVersion is the first byte of every encoded transaction (and
+// block, see chain, and identity). Bump it when any of the layouts change.
+const EncodingVersion = 5
+
+// MarshalBinary is the canonical encoding of a transaction, which is what
+// gets hashed, signed, stored and sent to peers. Integers are big-endian,
+// byte strings are prefixed with their length as a uint64:
+//   [1 byte EncodingVersion][1 byte Type]
+//   [len][Email][len][PublicKey.N, no leading zeros][8 byte PublicKey.E]
+//   [8 byte Sequence][32 byte PrevKeyHash][len][Nonce]
+//   [len][RecoveryKey.N, no leading zeros][8 byte RecoveryKey.E][8 byte RecoveryDelay]
+//   [len][Label][1 byte Purpose][len][Signature]
+// Every transaction has exactly one encoding, and UnmarshalBinary rejects
+// anything else.
+func (t Transaction) MarshalBinary() ([]byte, error) {
+	if t.Type < 0 || t.Type > math.MaxUint8 {
+		return nil, fmt.Errorf("transaction type %v doesn't fit the encoding", t.Type)
+	}
+	for _, key := range []*rsa.PublicKey{&t.PublicKey, &t.RecoveryKey} {
+		if key.E < 0 || (key.N != nil && key.N.Sign() < 0) {
+			return nil, fmt.Errorf("negative public key")
+		}
+	}
+	buf := []byte{EncodingVersion, byte(t.Type)}
+	buf = appendBytes(buf, []byte(t.Email))
+	buf = appendKey(buf, &t.PublicKey)
+	buf = binary.BigEndian.AppendUint64(buf, t.Sequence)
+	buf = append(buf, t.PrevKeyHash[:]...)
+	buf = appendBytes(buf, t.Nonce)
+	buf = appendKey(buf, &t.RecoveryKey)
+	buf = binary.BigEndian.AppendUint64(buf, t.RecoveryDelay)
+	buf = appendBytes(buf, []byte(t.Label))
+	buf = append(buf, byte(t.Purpose))
+	buf = appendBytes(buf, t.Signature)
+	return buf, nil
+}
+
+func (t *Transaction) UnmarshalBinary(data []byte) error {
+	d := decoder{data: data}
+	if v := d.byte(); d.err == nil && v != EncodingVersion {
+		return fmt.Errorf("transaction encoding version %v, we only know %v", v, EncodingVersion)
+	}
+	typ := d.byte()
+	email := d.bytes()
+	n := d.bytes()
+	e := d.uint64()
+	seq := d.uint64()
+	prev := d.take(uint64(len(t.PrevKeyHash)))
+	nonce := d.bytes()
+	rn := d.bytes()
+	re := d.uint64()
+	delay := d.uint64()
+	label := d.bytes()
+	purpose := d.byte()
+	sig := d.bytes()
+	if d.err != nil {
+		return fmt.Errorf("transaction: %v", d.err)
+	}
+	if len(d.data) > 0 {
+		return fmt.Errorf("transaction: %v bytes left over", len(d.data))
+	}
+	if (len(n) > 0 && n[0] == 0) || (len(rn) > 0 && rn[0] == 0) {
+		return fmt.Errorf("transaction: public key modulus has leading zeros")
+	}
+	if e > math.MaxInt32 || re > math.MaxInt32 {
+		return fmt.Errorf("transaction: public exponent too large")
+	}
+	*t = Transaction{Type: TransType(typ), Email: string(email), PublicKey: rsa.PublicKey{E: int(e)}, Sequence: seq}
+	copy(t.PrevKeyHash[:], prev)
+	t.RecoveryKey.E, t.RecoveryDelay = int(re), delay
+	t.Label, t.Purpose = string(label), Purpose(purpose)
+	if len(rn) > 0 {
+		t.RecoveryKey.N = new(big.Int).SetBytes(rn)
+	}
+	if len(n) > 0 {
+		t.PublicKey.N = new(big.Int).SetBytes(n)
+	}
+	if len(nonce) > 0 {
+		t.Nonce = nonce
+	}
+	if len(sig) > 0 {
+		t.Signature = sig
+	}
+	return nil
+}
+
+func appendBytes(buf []byte, data []byte) []byte {
+	buf = binary.BigEndian.AppendUint64(buf, uint64(len(data)))
+	return append(buf, data...)
+}
+
+// reads an encoding front to back; the first error sticks and the reads
+// after it return zero values
+type decoder struct {
+	data []byte
+	err  error
+}
+
+func (d *decoder) take(n uint64) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if uint64(len(d.data)) < n {
+		d.err = fmt.Errorf("truncated")
+		return nil
+	}
+	b := d.data[:n]
+	d.data = d.data[n:]
+	return b
+}
+
+func (d *decoder) byte() byte {
+	if b := d.take(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (d *decoder) uint64() uint64 {
+	if b := d.take(8); b != nil {
+		return binary.BigEndian.Uint64(b)
+	}
+	return 0
+}
+
+func (d *decoder) bytes() []byte {
+	n := d.uint64()
+	return append([]byte(nil), d.take(n)...)
+}
@@ -0,0 +1,140 @@
+package txn
+
+import (
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/binary"
+)
+
+// how many random bytes the CA puts in a registration, so that each of its
+// signatures is good for exactly one registration
+const NonceSize = 16
+
+// Identity is what the directory keeps for an email: its current key, and
+// the sequence number of the transaction that set it. Registrations are
+// number 0 and every update is one more than the last, so an old update
+// can never be applied again. Besides its current (primary) key it can
+// have a set of labelled keys for other devices and purposes; see KeySet.
+type Identity struct {
+	PublicKey rsa.PublicKey
+	Sequence  uint64
+	// the CA's nonce from the registration
+	Nonce []byte
+	// a revoked identity keeps the key it had, which nobody should use;
+	// RevokedAt is the SeqNum of the block the revocation is in
+	Revoked   bool
+	RevokedAt uint64
+	// from the registration, if it had one (RecoveryKey.N is nil if not)
+	RecoveryKey   rsa.PublicKey
+	RecoveryDelay uint64
+	// a pending recovery: PendingKey takes over from the block at RecoverAt
+	// on, 0 if there is none. The directory keeps it pending until the
+	// identity's next transaction; see Settle.
+	PendingKey rsa.PublicKey
+	RecoverAt  uint64
+	// added with AddKey, oldest first
+	Keys []Key
+}
+
+// Bytes is what the directory commits to for an identity:
+//   [1 byte EncodingVersion][8 byte Sequence]
+//   [len][PublicKey.N, no leading zeros][8 byte PublicKey.E][len][Nonce]
+//   [1 byte Revoked, 0 or 1][8 byte RevokedAt]
+//   [len][RecoveryKey.N][8 byte RecoveryKey.E][8 byte RecoveryDelay]
+//   [len][PendingKey.N][8 byte PendingKey.E][8 byte RecoverAt]
+//   [8 byte len(Keys)] then for each [len][Label][1 byte Purpose][len][N][8 byte E]
+// so a proof of an identity covers its whole key set.
+func (id *Identity) Bytes() []byte {
+	buf := []byte{EncodingVersion}
+	buf = binary.BigEndian.AppendUint64(buf, id.Sequence)
+	buf = appendKey(buf, &id.PublicKey)
+	buf = appendBytes(buf, id.Nonce)
+	if id.Revoked {
+		buf = append(buf, 1)
+	} else {
+		buf = append(buf, 0)
+	}
+	buf = binary.BigEndian.AppendUint64(buf, id.RevokedAt)
+	buf = appendKey(buf, &id.RecoveryKey)
+	buf = binary.BigEndian.AppendUint64(buf, id.RecoveryDelay)
+	buf = appendKey(buf, &id.PendingKey)
+	buf = binary.BigEndian.AppendUint64(buf, id.RecoverAt)
+	buf = binary.BigEndian.AppendUint64(buf, uint64(len(id.Keys)))
+	for i := range id.Keys {
+		k := &id.Keys[i]
+		buf = appendBytes(buf, []byte(k.Label))
+		buf = append(buf, byte(k.Purpose))
+		buf = appendKey(buf, &k.PublicKey)
+	}
+	return buf
+}
+
+// [len][key.N, no leading zeros][8 byte key.E]
+func appendKey(buf []byte, key *rsa.PublicKey) []byte {
+	var n []byte
+	if key.N != nil {
+		n = key.N.Bytes()
+	}
+	buf = appendBytes(buf, n)
+	return binary.BigEndian.AppendUint64(buf, uint64(key.E))
+}
+
+// KeyHash is what an update names the key it replaces by: the SHA-256 of
+//   [len][key.N, no leading zeros][8 byte key.E]
+func KeyHash(key *rsa.PublicKey) [sha256.Size]byte {
+	return sha256.Sum256(appendKey(nil, key))
+}
+
+// Settle is id as of the block at seqNum: if a pending recovery's delay is
+// up by then, its key is the identity's, and the only one (whoever lost
+// the old key may have lost the others too). Lookups and Validate go by
+// the settled identity; nil stays nil.
+func Settle(id *Identity, seqNum uint64) *Identity {
+	if id == nil || id.RecoverAt == 0 || seqNum < id.RecoverAt {
+		return id
+	}
+	settled := *id
+	settled.PublicKey, settled.PendingKey, settled.RecoverAt = id.PendingKey, rsa.PublicKey{}, 0
+	settled.Keys = nil
+	return &settled
+}
+
+// Apply is the identity an email has after t, in the block at seqNum, given
+// the one it had before settled for that block (nil if it wasn't
+// registered). t must have passed Validate.
+func Apply(id *Identity, t *Transaction, seqNum uint64) Identity {
+	if id == nil {
+		return Identity{
+			PublicKey:     t.PublicKey,
+			Sequence:      t.Sequence,
+			Nonce:         t.Nonce,
+			RecoveryKey:   t.RecoveryKey,
+			RecoveryDelay: t.RecoveryDelay,
+		}
+	}
+	next := *id
+	next.Sequence = t.Sequence
+	// anything the current key signs ends a pending recovery
+	next.PendingKey, next.RecoverAt = rsa.PublicKey{}, 0
+	switch t.Type {
+	case Update:
+		next.PublicKey = t.PublicKey
+	case Revoke:
+		next.Revoked, next.RevokedAt = true, seqNum
+	case Recover:
+		// the current key has the RecoveryDelay blocks after this one to
+		// cancel in
+		next.PendingKey, next.RecoverAt = t.PublicKey, seqNum+id.RecoveryDelay+1
+	case AddKey:
+		// a fresh slice, since id (and its Keys) may be kept for undoing
+		next.Keys = append(append([]Key(nil), id.Keys...), Key{Label: t.Label, Purpose: t.Purpose, PublicKey: t.PublicKey})
+	case RemoveKey:
+		next.Keys = nil
+		for _, k := range id.Keys {
+			if k.Label != t.Label {
+				next.Keys = append(next.Keys, k)
+			}
+		}
+	}
+	return next
+}
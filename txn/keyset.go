@@ -0,0 +1,134 @@
+package txn
+
+import (
+	"crypto/rsa"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// Purpose says what a key in an identity's key set is for; a key can have
+// several
+type Purpose uint8
+
+const (
+	PurposeSign Purpose = 1 << iota
+	PurposeEncrypt
+	// may add and remove the identity's other keys
+	PurposeManage
+
+	PurposeAll = PurposeSign | PurposeEncrypt | PurposeManage
+)
+
+// limits on an identity's key set, which goes into every proof of it
+const (
+	MaxKeys        = 16 // besides the primary key
+	MaxLabelLength = 64
+)
+
+// the identity's own key, the one updates, revocations and recoveries act
+// on, is in its key set under this label with every purpose
+const PrimaryLabel = "primary"
+
+var purposeNames = []struct {
+	p    Purpose
+	name string
+}{
+	{PurposeSign, "sign"},
+	{PurposeEncrypt, "encrypt"},
+	{PurposeManage, "manage"},
+}
+
+// like "sign,encrypt"
+func (p Purpose) String() string {
+	var names []string
+	for _, pn := range purposeNames {
+		if p&pn.p != 0 {
+			names = append(names, pn.name)
+			p &^= pn.p
+		}
+	}
+	if p != 0 {
+		names = append(names, fmt.Sprintf("Purpose(%#x)", uint8(p)))
+	}
+	return strings.Join(names, ",")
+}
+
+// ParsePurpose reads what Purpose.String writes; "" is no purpose
+func ParsePurpose(s string) (Purpose, error) {
+	var p Purpose
+	if s == "" {
+		return p, nil
+	}
+	for _, name := range strings.Split(s, ",") {
+		found := false
+		for _, pn := range purposeNames {
+			if name == pn.name {
+				p |= pn.p
+				found = true
+			}
+		}
+		if !found {
+			return 0, fmt.Errorf("unknown key purpose %q", name)
+		}
+	}
+	return p, nil
+}
+
+// Key is one key of an identity's key set
+type Key struct {
+	Label     string
+	Purpose   Purpose
+	PublicKey rsa.PublicKey
+}
+
+// KeySet is every key id has: the primary key first, then the ones added
+// to it in the order they were added
+func (id *Identity) KeySet() []Key {
+	keys := []Key{{Label: PrimaryLabel, Purpose: PurposeAll, PublicKey: id.PublicKey}}
+	return append(keys, id.Keys...)
+}
+
+// WithPurpose is the keys that have every purpose in p
+func WithPurpose(keys []Key, p Purpose) []Key {
+	var out []Key
+	for _, k := range keys {
+		if k.Purpose&p == p {
+			out = append(out, k)
+		}
+	}
+	return out
+}
+
+// the index of the key labelled label in id.Keys, -1 if there isn't one
+func (id *Identity) findLabel(label string) int {
+	for i, k := range id.Keys {
+		if k.Label == label {
+			return i
+		}
+	}
+	return -1
+}
+
+// whether key is anywhere in id's key set
+func (id *Identity) hasKey(key *rsa.PublicKey) bool {
+	for _, k := range id.KeySet() {
+		if k.PublicKey.E == key.E && k.PublicKey.N != nil && key.N != nil && k.PublicKey.N.Cmp(key.N) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func checkLabel(label string) error {
+	if label == "" || len(label) > MaxLabelLength {
+		return fmt.Errorf("Key label must be 1 to %v bytes", MaxLabelLength)
+	}
+	if !utf8.ValidString(label) || strings.IndexFunc(label, func(r rune) bool { return r < ' ' || r == 0x7f }) >= 0 {
+		return fmt.Errorf("Key label must be printable UTF-8")
+	}
+	if label == PrimaryLabel {
+		return fmt.Errorf("Key label %q is taken by the primary key", PrimaryLabel)
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package txn
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+)
+
+// what a signature is for, first thing in every signing payload, so a
+// signature over one kind of message can't pass for another
+const (
+	RegisterDomain       = "SLYkey register v3"
+	UpdateDomain         = "SLYkey update v2"
+	RevokeDomain         = "SLYkey revoke v1"
+	RecoverDomain        = "SLYkey recover v1"
+	CancelRecoveryDomain = "SLYkey cancel recovery v1"
+	AddKeyDomain         = "SLYkey add key v1"
+	RemoveKeyDomain      = "SLYkey remove key v1"
+)
+
+// SigningPayload is what gets signed for t on the network chainID (see
+// chain.ChainParams): the CA signs registrations, an email's recovery key
+// signs its recoveries, and its current key everything else (revocations
+// can come from either, and changes to the key set from any key in it with
+// PurposeManage). Byte strings are prefixed with their length as a
+// big-endian uint64:
+//   [len][domain][len][chainID][len][Email][len][PublicKey.N][8 byte PublicKey.E]
+//   [8 byte Sequence] then for registrations
+//   [len][Nonce][len][RecoveryKey.N][8 byte RecoveryKey.E][8 byte RecoveryDelay]
+// or else [32 byte PrevKeyHash], and then for key additions and removals
+//   [len][Label][1 byte Purpose]
+// with the domain for the type (RegisterDomain and so on); revocations,
+// cancellations and removals have an empty key. It is
+// separate from the transaction's encoding; the signature itself is never
+// part of it.
+func SigningPayload(t *Transaction, chainID string) ([]byte, error) {
+	var domain string
+	switch t.Type {
+	case Register:
+		domain = RegisterDomain
+	case Update:
+		domain = UpdateDomain
+	case Revoke:
+		domain = RevokeDomain
+	case Recover:
+		domain = RecoverDomain
+	case CancelRecovery:
+		domain = CancelRecoveryDomain
+	case AddKey:
+		domain = AddKeyDomain
+	case RemoveKey:
+		domain = RemoveKeyDomain
+	default:
+		return nil, fmt.Errorf("nothing signs transactions of type %v", t.Type)
+	}
+	if t.PublicKey.E < 0 || t.RecoveryKey.E < 0 {
+		return nil, fmt.Errorf("negative public key")
+	}
+	var buf []byte
+	buf = appendBytes(buf, []byte(domain))
+	buf = appendBytes(buf, []byte(chainID))
+	buf = appendBytes(buf, []byte(t.Email))
+	buf = appendKey(buf, &t.PublicKey)
+	buf = binary.BigEndian.AppendUint64(buf, t.Sequence)
+	if t.Type == Register {
+		buf = appendBytes(buf, t.Nonce)
+		buf = appendKey(buf, &t.RecoveryKey)
+		buf = binary.BigEndian.AppendUint64(buf, t.RecoveryDelay)
+	} else {
+		buf = append(buf, t.PrevKeyHash[:]...)
+	}
+	if t.Type == AddKey || t.Type == RemoveKey {
+		buf = appendBytes(buf, []byte(t.Label))
+		buf = append(buf, byte(t.Purpose))
+	}
+	return buf, nil
+}
+
+// SigHash is the SHA-256 of t's SigningPayload, which is what the signature
+// is over (PKCS #1 v1.5)
+func SigHash(t *Transaction, chainID string) ([sha256.Size]byte, error) {
+	payload, err := SigningPayload(t, chainID)
+	if err != nil {
+		return [sha256.Size]byte{}, err
+	}
+	return sha256.Sum256(payload), nil
+}
+
+// Sign sets t's signature, by priv, for the network chainID
+func Sign(t *Transaction, priv *rsa.PrivateKey, chainID string) error {
+	hash, err := SigHash(t, chainID)
+	if err != nil {
+		return err
+	}
+	sig, err := rsa.SignPKCS1v15(rand.Reader, priv, crypto.SHA256, hash[:])
+	if err != nil {
+		return err
+	}
+	t.Signature = sig
+	return nil
+}
+
+// VerifySignature checks that t is signed by key for the network chainID
+func VerifySignature(t *Transaction, key *rsa.PublicKey, chainID string) error {
+	hash, err := SigHash(t, chainID)
+	if err != nil {
+		return err
+	}
+	return rsa.VerifyPKCS1v15(key, crypto.SHA256, hash[:], t.Signature)
+}
@@ -0,0 +1,245 @@
+// Package txn defines the transactions that go into SLYkey blocks and the
+// rules a single transaction has to follow.
+package txn
+
+import (
+	"crypto/rsa"
+	"crypto/sha256"
+	"fmt"
+)
+
+type TransType int
+
+type Transaction struct {
+	Type      TransType     `json:"type"`
+	Email     string        `json:"email"`
+	PublicKey rsa.PublicKey `json:"public_key"`
+	// 0 for a registration, one more than the identity's for anything after
+	Sequence uint64 `json:"sequence"`
+	// everything but registrations: KeyHash of the identity's current key
+	PrevKeyHash [sha256.Size]byte `json:"prev_key_hash"`
+	// registrations only: the CA's nonce
+	Nonce []byte `json:"nonce"`
+	// registrations only, and optional: a key that can Recover the identity,
+	// and how many blocks after the recovery's own the current key has to
+	// cancel it in (at least MinRecoveryDelay)
+	RecoveryKey   rsa.PublicKey `json:"recovery_key"`
+	RecoveryDelay uint64        `json:"recovery_delay"`
+	// adding and removing keys only: which key of the set, and (adding)
+	// what it's for
+	Label     string  `json:"label"`
+	Purpose   Purpose `json:"purpose"`
+	Signature []byte
+}
+
+const (
+	Register TransType = 1 + iota
+	Update
+	// kills an identity's key without a replacement; the email can't be
+	// registered or updated afterwards. PublicKey is empty.
+	Revoke
+	// signed by the recovery key: PublicKey becomes the identity's key once
+	// its RecoveryDelay is up, unless the current key cancels it first
+	Recover
+	// signed by the current key: drops a pending recovery. PublicKey is
+	// empty.
+	CancelRecovery
+	// signed by the primary key or one with PurposeManage: PublicKey joins
+	// the identity's key set as Label, for Purpose
+	AddKey
+	// signed like AddKey: the key labelled Label leaves the key set.
+	// PublicKey is empty.
+	RemoveKey
+)
+
+// shortest recovery delay a registration can ask for, in blocks; the
+// current key needs at least one block to cancel in
+const MinRecoveryDelay = 1
+
+func (tt TransType) String() string {
+	switch tt {
+	case Register:
+		return "Registration"
+	case Update:
+		return "Update"
+	case Revoke:
+		return "Revocation"
+	case Recover:
+		return "Recovery"
+	case CancelRecovery:
+		return "Recovery cancellation"
+	case AddKey:
+		return "Key addition"
+	case RemoveKey:
+		return "Key removal"
+	}
+	return fmt.Sprintf("TransType(%d)", int(tt))
+}
+
+// Hash identifies a transaction when announcing it to peers: the SHA-256
+// of its encoding (see MarshalBinary)
+func (t *Transaction) Hash() [sha256.Size]byte {
+	data, _ := t.MarshalBinary()
+	return sha256.Sum256(data)
+}
+
+// UsableKey says whether key could be anyone's key at all: a positive
+// modulus and an exponent past 1
+func UsableKey(key *rsa.PublicKey) bool {
+	return key.N != nil && key.N.Sign() > 0 && key.E > 1
+}
+
+// Validate checks a single transaction on the network chainID given the
+// identity its email has before it, settled for the block t goes in (see
+// Settle; nil if the email isn't registered); registrations must be signed
+// by caKey
+func Validate(t *Transaction, last *Identity, caKey *rsa.PublicKey, chainID string) error {
+	if _, err := t.MarshalBinary(); err != nil {
+		return err
+	}
+	hasRecovery := t.RecoveryKey.N != nil || t.RecoveryKey.E != 0 || t.RecoveryDelay != 0
+	if hasRecovery && t.Type != Register {
+		return fmt.Errorf("Only registrations set a recovery key")
+	}
+	if (t.Label != "" || t.Purpose != 0) && t.Type != AddKey && t.Type != RemoveKey {
+		return fmt.Errorf("Only adding or removing a key names one")
+	}
+	if last != nil && last.Revoked {
+		return fmt.Errorf("%v was revoked in block %v", t.Email, last.RevokedAt)
+	}
+	if last == nil {
+		// did not find previous transaction of this user
+		// must be a registration and signed by the CA
+		if t.Type != Register {
+			return fmt.Errorf("Cannot update a nonexistent public key")
+		}
+		if t.Sequence != 0 {
+			return fmt.Errorf("Registration must have sequence number 0, not %v", t.Sequence)
+		}
+		if len(t.Nonce) != NonceSize {
+			return fmt.Errorf("Registration needs a %v byte CA nonce", NonceSize)
+		}
+		if t.PrevKeyHash != ([sha256.Size]byte{}) {
+			return fmt.Errorf("Registration cannot replace a key")
+		}
+		if !UsableKey(&t.PublicKey) {
+			return fmt.Errorf("Registration needs a key")
+		}
+		if hasRecovery && (!UsableKey(&t.RecoveryKey) || t.RecoveryDelay < MinRecoveryDelay) {
+			return fmt.Errorf("Recovery key needs a delay of at least %v blocks", MinRecoveryDelay)
+		}
+		if caKey == nil || caKey.N == nil {
+			return fmt.Errorf("No CA key to check registrations with")
+		}
+		// verify the CA signed this request
+		if err := VerifySignature(t, caKey, chainID); err != nil {
+			return fmt.Errorf("Not signed by the CA")
+		}
+		return nil
+	}
+	// else this is a change to an existing identity
+	if t.Type == Register {
+		return fmt.Errorf("Cannot register if you already are in the database")
+	}
+	hasKey := t.PublicKey.N != nil || t.PublicKey.E != 0
+	// who may sign it: the current key, and for some the recovery key or
+	// keys in the set
+	signers := []*rsa.PublicKey{&last.PublicKey}
+	switch t.Type {
+	case Update:
+		if !UsableKey(&t.PublicKey) {
+			return fmt.Errorf("Update needs a new key")
+		}
+		if last.hasKey(&t.PublicKey) {
+			return fmt.Errorf("%v already has that key", t.Email)
+		}
+	case Revoke:
+		if hasKey {
+			return fmt.Errorf("Revocation cannot carry a new key")
+		}
+		if last.RecoveryKey.N != nil {
+			signers = append(signers, &last.RecoveryKey)
+		}
+	case Recover:
+		if last.RecoveryKey.N == nil {
+			return fmt.Errorf("%v has no recovery key", t.Email)
+		}
+		if last.RecoverAt != 0 {
+			return fmt.Errorf("A recovery of %v is already pending", t.Email)
+		}
+		if !UsableKey(&t.PublicKey) {
+			return fmt.Errorf("Recovery needs a new key")
+		}
+		signers = []*rsa.PublicKey{&last.RecoveryKey}
+	case CancelRecovery:
+		if last.RecoverAt == 0 {
+			return fmt.Errorf("No recovery of %v to cancel", t.Email)
+		}
+		if hasKey {
+			return fmt.Errorf("Cancelling a recovery cannot carry a new key")
+		}
+	case AddKey, RemoveKey:
+		// a recovery that lands drops the added keys; they can't change
+		// under it, or a key with PurposeManage could hold it off
+		if last.RecoverAt != 0 {
+			return fmt.Errorf("A recovery of %v is pending", t.Email)
+		}
+		if err := checkKeyChange(t, last, hasKey); err != nil {
+			return err
+		}
+		for i := range last.Keys {
+			if last.Keys[i].Purpose&PurposeManage != 0 {
+				signers = append(signers, &last.Keys[i].PublicKey)
+			}
+		}
+	default:
+		return fmt.Errorf("Unknown transaction type %v", t.Type)
+	}
+	if t.Sequence != last.Sequence+1 {
+		return fmt.Errorf("%v has sequence number %v, expected %v", t.Type, t.Sequence, last.Sequence+1)
+	}
+	if t.PrevKeyHash != KeyHash(&last.PublicKey) {
+		return fmt.Errorf("%v does not name the current key", t.Type)
+	}
+	if len(t.Nonce) > 0 {
+		return fmt.Errorf("Only registrations carry a nonce")
+	}
+	for _, key := range signers {
+		if VerifySignature(t, key, chainID) == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("Signature on new transaction does not match")
+}
+
+// the rules for t, an AddKey or RemoveKey, against the key set of last
+func checkKeyChange(t *Transaction, last *Identity, hasKey bool) error {
+	if t.Type == RemoveKey {
+		if hasKey || t.Purpose != 0 {
+			return fmt.Errorf("Removing a key cannot carry a key or purpose")
+		}
+		if last.findLabel(t.Label) < 0 {
+			return fmt.Errorf("%v has no key labelled %q", t.Email, t.Label)
+		}
+		return nil
+	}
+	if err := checkLabel(t.Label); err != nil {
+		return err
+	}
+	if t.Purpose == 0 || t.Purpose&^PurposeAll != 0 {
+		return fmt.Errorf("Key purpose %v is not one we know", t.Purpose)
+	}
+	if !UsableKey(&t.PublicKey) {
+		return fmt.Errorf("Adding a key needs a key")
+	}
+	if last.findLabel(t.Label) >= 0 {
+		return fmt.Errorf("%v already has a key labelled %q", t.Email, t.Label)
+	}
+	if last.hasKey(&t.PublicKey) {
+		return fmt.Errorf("%v already has that key", t.Email)
+	}
+	if len(last.Keys) >= MaxKeys {
+		return fmt.Errorf("%v already has %v keys besides its primary one", t.Email, MaxKeys)
+	}
+	return nil
+}